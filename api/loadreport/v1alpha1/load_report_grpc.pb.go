@@ -0,0 +1,142 @@
+// Copyright 2024.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: loadreport/v1alpha1/load_report.proto
+
+package loadreportv1alpha1
+
+import (
+	context "context"
+	v3 "github.com/cncf/xds/go/xds/data/orca/v3"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	LoadReportService_StreamLoadReports_FullMethodName = "/llm_instance_gateway.v1alpha1.LoadReportService/StreamLoadReports"
+)
+
+// LoadReportServiceClient is the client API for LoadReportService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// LoadReportService receives the ORCA load reports pushed by the model servers, e.g. by a sidecar,
+// so that the ext-proc does not poll their metrics.
+type LoadReportServiceClient interface {
+	// StreamLoadReports applies the load reports of a pod to its metrics until the pod closes the
+	// stream. The pod is named by the "x-pod-name" metadata, and the stream must come from the
+	// address of the pod.
+	StreamLoadReports(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[v3.OrcaLoadReport, emptypb.Empty], error)
+}
+
+type loadReportServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLoadReportServiceClient(cc grpc.ClientConnInterface) LoadReportServiceClient {
+	return &loadReportServiceClient{cc}
+}
+
+func (c *loadReportServiceClient) StreamLoadReports(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[v3.OrcaLoadReport, emptypb.Empty], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LoadReportService_ServiceDesc.Streams[0], LoadReportService_StreamLoadReports_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[v3.OrcaLoadReport, emptypb.Empty]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LoadReportService_StreamLoadReportsClient = grpc.ClientStreamingClient[v3.OrcaLoadReport, emptypb.Empty]
+
+// LoadReportServiceServer is the server API for LoadReportService service.
+// All implementations must embed UnimplementedLoadReportServiceServer
+// for forward compatibility.
+//
+// LoadReportService receives the ORCA load reports pushed by the model servers, e.g. by a sidecar,
+// so that the ext-proc does not poll their metrics.
+type LoadReportServiceServer interface {
+	// StreamLoadReports applies the load reports of a pod to its metrics until the pod closes the
+	// stream. The pod is named by the "x-pod-name" metadata, and the stream must come from the
+	// address of the pod.
+	StreamLoadReports(grpc.ClientStreamingServer[v3.OrcaLoadReport, emptypb.Empty]) error
+	mustEmbedUnimplementedLoadReportServiceServer()
+}
+
+// UnimplementedLoadReportServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedLoadReportServiceServer struct{}
+
+func (UnimplementedLoadReportServiceServer) StreamLoadReports(grpc.ClientStreamingServer[v3.OrcaLoadReport, emptypb.Empty]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamLoadReports not implemented")
+}
+func (UnimplementedLoadReportServiceServer) mustEmbedUnimplementedLoadReportServiceServer() {}
+func (UnimplementedLoadReportServiceServer) testEmbeddedByValue()                           {}
+
+// UnsafeLoadReportServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LoadReportServiceServer will
+// result in compilation errors.
+type UnsafeLoadReportServiceServer interface {
+	mustEmbedUnimplementedLoadReportServiceServer()
+}
+
+func RegisterLoadReportServiceServer(s grpc.ServiceRegistrar, srv LoadReportServiceServer) {
+	// If the following call pancis, it indicates UnimplementedLoadReportServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&LoadReportService_ServiceDesc, srv)
+}
+
+func _LoadReportService_StreamLoadReports_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(LoadReportServiceServer).StreamLoadReports(&grpc.GenericServerStream[v3.OrcaLoadReport, emptypb.Empty]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LoadReportService_StreamLoadReportsServer = grpc.ClientStreamingServer[v3.OrcaLoadReport, emptypb.Empty]
+
+// LoadReportService_ServiceDesc is the grpc.ServiceDesc for LoadReportService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LoadReportService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "llm_instance_gateway.v1alpha1.LoadReportService",
+	HandlerType: (*LoadReportServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamLoadReports",
+			Handler:       _LoadReportService_StreamLoadReports_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "loadreport/v1alpha1/load_report.proto",
+}
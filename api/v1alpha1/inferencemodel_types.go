@@ -55,6 +55,13 @@ type InferenceModelSpec struct {
 	// +optional
 	// +kubebuilder:default="Default"
 	Criticality *Criticality `json:"criticality,omitempty"`
+	// Allows requests to raise their criticality above the one defined by this model by setting
+	// the criticality request header. Requests may always lower their criticality, regardless of
+	// this setting.
+	//
+	// +optional
+	// +kubebuilder:default=false
+	AllowCriticalityIncrease *bool `json:"allowCriticalityIncrease,omitempty"`
 	// Allow multiple versions of a model for traffic splitting.
 	// If not specified, the target model name is defaulted to the modelName parameter.
 	// modelName is often in reference to a LoRA adapter.
@@ -62,6 +69,39 @@ type InferenceModelSpec struct {
 	// +optional
 	// +kubebuilder:validation:MaxItems=10
 	TargetModels []TargetModel `json:"targetModels,omitempty"`
+	// Routes the requests matching a rule to the target model of the rule, regardless of the
+	// weights of the target models. The rules are evaluated in order and the first matching rule
+	// applies. Requests matching no rule are split according to the weights of the target models.
+	//
+	// +optional
+	// +kubebuilder:validation:MaxItems=16
+	Rules []TargetModelRule `json:"rules,omitempty"`
+	// The target models requests are sent to, in order, when there is no capacity left for the
+	// target model picked for them, e.g. a smaller base model or a different adapter. A request is
+	// shed if there is no capacity for any of them.
+	//
+	// +optional
+	// +kubebuilder:validation:MaxItems=5
+	// +kubebuilder:validation:items:MaxLength=253
+	Fallbacks []string `json:"fallbacks,omitempty"`
+	// Progressively shifts traffic to one of the target models, e.g. a new version of an adapter,
+	// and rolls back if the target model misbehaves. During the rollout, the weight of the rollout
+	// target model is ignored, and the other target models share the remaining traffic according to
+	// their weights. A rolled back rollout is retried by removing it and adding it again.
+	//
+	// +optional
+	Rollout *ModelRollout `json:"rollout,omitempty"`
+	// Limits the number of tokens requests for this model may use over a period. Requests are
+	// rejected with a 429 response once the quota is exhausted, until the next period.
+	//
+	// +optional
+	Quota *TokenQuota `json:"quota,omitempty"`
+	// Limits the size and the parameters of the requests for this model. Requests exceeding the
+	// limits are rejected before they are scheduled, with a 413 response if the body is too large
+	// and a 400 response otherwise.
+	//
+	// +optional
+	Limits *RequestLimits `json:"limits,omitempty"`
 	// Reference to the inference pool, the pool must exist in the same namespace.
 	//
 	// +kubebuilder:validation:Required
@@ -134,12 +174,222 @@ type TargetModel struct {
 	Weight int32 `json:"weight,omitempty"`
 }
 
+// TargetModelRule routes the requests matching all of its matches to a target model.
+type TargetModelRule struct {
+	// The conditions a request must all meet for the rule to apply.
+	//
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=8
+	// +kubebuilder:validation:Required
+	Matches []RequestMatch `json:"matches"`
+	// The name of the target model the matching requests are sent to, as expected by the
+	// ModelServer. It does not need to be one of the targetModels.
+	//
+	// +kubebuilder:validation:MaxLength=253
+	// +kubebuilder:validation:Required
+	TargetModel string `json:"targetModel"`
+}
+
+// RequestMatch is a condition on a request header or a top-level request body field.
+type RequestMatch struct {
+	// Whether a request header or a top-level request body field is matched.
+	//
+	// +kubebuilder:validation:Required
+	Type MatchType `json:"type"`
+	// The name of the header, which is case insensitive, or of the body field, e.g. "max_tokens".
+	//
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=256
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+	// How the header or body field is compared to the value. GreaterThan and LessThan compare
+	// numbers, and never match values which are not numbers.
+	//
+	// +optional
+	// +kubebuilder:default="Exact"
+	Operator MatchOperator `json:"operator,omitempty"`
+	// The value the header or body field is compared to. It is ignored by the Present operator.
+	//
+	// +optional
+	// +kubebuilder:validation:MaxLength=4096
+	Value string `json:"value,omitempty"`
+}
+
+// MatchType is the part of a request a RequestMatch applies to.
+// +kubebuilder:validation:Enum=Header;BodyField
+type MatchType string
+
+const (
+	// Matches a request header.
+	HeaderMatch MatchType = "Header"
+	// Matches a top-level field of the JSON request body.
+	BodyFieldMatch MatchType = "BodyField"
+)
+
+// MatchOperator defines how a RequestMatch compares a request header or body field to its value.
+// +kubebuilder:validation:Enum=Exact;GreaterThan;LessThan;Present
+type MatchOperator string
+
+const (
+	// Matches if the header or body field is equal to the value.
+	MatchExact MatchOperator = "Exact"
+	// Matches if the header or body field is a number greater than the value.
+	MatchGreaterThan MatchOperator = "GreaterThan"
+	// Matches if the header or body field is a number less than the value.
+	MatchLessThan MatchOperator = "LessThan"
+	// Matches if the header or body field is set.
+	MatchPresent MatchOperator = "Present"
+)
+
+// ModelRollout defines a progressive rollout of a target model.
+type ModelRollout struct {
+	// The name of the target model traffic is shifted to. It must match the name of one of the
+	// targetModels.
+	//
+	// +kubebuilder:validation:MaxLength=253
+	// +kubebuilder:validation:Required
+	TargetModel string `json:"targetModel"`
+	// The schedule of the rollout. Each step sends a percentage of the traffic to the target model
+	// for a duration, after which the rollout moves to the next step. Once the last step has
+	// elapsed, the rollout succeeds and keeps the percentage of the last step.
+	//
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=10
+	// +kubebuilder:validation:Required
+	Steps []RolloutStep `json:"steps"`
+	// The maximum percentage of failed requests to the target model before the rollout is rolled
+	// back. Requests failing with a 5xx status code are considered failed.
+	//
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	MaxErrorPercent *int32 `json:"maxErrorPercent,omitempty"`
+	// The maximum average latency of requests to the target model before the rollout is rolled
+	// back.
+	//
+	// +optional
+	MaxLatency *metav1.Duration `json:"maxLatency,omitempty"`
+	// The minimum number of requests to the target model before its error rate and latency are
+	// compared against the thresholds.
+	//
+	// +optional
+	// +kubebuilder:default=10
+	// +kubebuilder:validation:Minimum=1
+	MinRequests int32 `json:"minRequests,omitempty"`
+}
+
+// RolloutStep is a step of a ModelRollout.
+type RolloutStep struct {
+	// The percentage of the traffic sent to the rollout target model during this step.
+	//
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +kubebuilder:validation:Required
+	Percent int32 `json:"percent"`
+	// How long the step lasts.
+	//
+	// +kubebuilder:validation:Required
+	Duration metav1.Duration `json:"duration"`
+}
+
+// TokenQuota limits the number of tokens, prompt and completion tokens combined, used by the
+// requests for a model over a period.
+type TokenQuota struct {
+	// The maximum number of tokens used per period.
+	//
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Required
+	Tokens int64 `json:"tokens"`
+	// The period the quota applies to. The periods start on the hour, or at midnight UTC.
+	//
+	// +kubebuilder:validation:Required
+	Period QuotaPeriod `json:"period"`
+	// Whether each tenant, identified by the tenant request header configured on the gateway, gets
+	// its own quota, rather than all requests sharing one quota. Requests without the tenant header
+	// share one quota.
+	//
+	// +optional
+	// +kubebuilder:default=false
+	PerTenant bool `json:"perTenant,omitempty"`
+}
+
+// QuotaPeriod is the period a TokenQuota applies to.
+// +kubebuilder:validation:Enum=Hour;Day
+type QuotaPeriod string
+
+const (
+	QuotaPeriodHour QuotaPeriod = "Hour"
+	QuotaPeriodDay  QuotaPeriod = "Day"
+)
+
+// RequestLimits limits the size and the parameters of requests.
+type RequestLimits struct {
+	// The maximum size of the request body, in bytes.
+	//
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	MaxBodyBytes *int64 `json:"maxBodyBytes,omitempty"`
+	// The maximum length of the prompt, in characters. The length of the prompt of chat completion
+	// requests is the total length of the content of their messages.
+	//
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	MaxPromptLength *int32 `json:"maxPromptLength,omitempty"`
+	// The maximum value of the "max_tokens" and "max_completion_tokens" parameters.
+	//
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	MaxTokens *int32 `json:"maxTokens,omitempty"`
+	// The top-level request body parameters requests may set, e.g. "prompt" and "temperature".
+	// The "model" parameter is always allowed. All parameters are allowed if empty.
+	//
+	// +optional
+	// +kubebuilder:validation:MaxItems=64
+	// +kubebuilder:validation:items:MaxLength=256
+	AllowedParameters []string `json:"allowedParameters,omitempty"`
+}
+
 // InferenceModelStatus defines the observed state of InferenceModel
 type InferenceModelStatus struct {
 	// Conditions track the state of the InferencePool.
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// The progress of the rollout, if any.
+	//
+	// +optional
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
 }
 
+// RolloutStatus is the observed state of a ModelRollout.
+type RolloutStatus struct {
+	// The name of the target model being rolled out.
+	TargetModel string `json:"targetModel"`
+	// The phase of the rollout.
+	Phase RolloutPhase `json:"phase"`
+	// The index of the current step of the rollout.
+	Step int32 `json:"step"`
+	// The percentage of the traffic currently sent to the rollout target model.
+	Percent int32 `json:"percent"`
+	// When the rollout started.
+	StartTime metav1.Time `json:"startTime"`
+	// A human readable message about the state of the rollout, such as the reason of a rollback.
+	//
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// RolloutPhase is the phase of a ModelRollout.
+// +kubebuilder:validation:Enum=Progressing;Succeeded;RolledBack
+type RolloutPhase string
+
+const (
+	// The rollout is shifting traffic to the target model.
+	RolloutProgressing RolloutPhase = "Progressing"
+	// The last step of the rollout has elapsed without the target model exceeding the thresholds.
+	RolloutSucceeded RolloutPhase = "Succeeded"
+	// The target model exceeded the thresholds, and no traffic is sent to it anymore.
+	RolloutRolledBack RolloutPhase = "RolledBack"
+)
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +genclient
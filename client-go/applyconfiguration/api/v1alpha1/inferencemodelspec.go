@@ -24,10 +24,16 @@ import (
 // InferenceModelSpecApplyConfiguration represents a declarative configuration of the InferenceModelSpec type for use
 // with apply.
 type InferenceModelSpecApplyConfiguration struct {
-	ModelName    *string                                `json:"modelName,omitempty"`
-	Criticality  *v1alpha1.Criticality                  `json:"criticality,omitempty"`
-	TargetModels []TargetModelApplyConfiguration        `json:"targetModels,omitempty"`
-	PoolRef      *PoolObjectReferenceApplyConfiguration `json:"poolRef,omitempty"`
+	ModelName                *string                                `json:"modelName,omitempty"`
+	Criticality              *v1alpha1.Criticality                  `json:"criticality,omitempty"`
+	AllowCriticalityIncrease *bool                                  `json:"allowCriticalityIncrease,omitempty"`
+	TargetModels             []TargetModelApplyConfiguration        `json:"targetModels,omitempty"`
+	Rules                    []TargetModelRuleApplyConfiguration    `json:"rules,omitempty"`
+	Fallbacks                []string                               `json:"fallbacks,omitempty"`
+	Rollout                  *ModelRolloutApplyConfiguration        `json:"rollout,omitempty"`
+	Quota                    *TokenQuotaApplyConfiguration          `json:"quota,omitempty"`
+	Limits                   *RequestLimitsApplyConfiguration       `json:"limits,omitempty"`
+	PoolRef                  *PoolObjectReferenceApplyConfiguration `json:"poolRef,omitempty"`
 }
 
 // InferenceModelSpecApplyConfiguration constructs a declarative configuration of the InferenceModelSpec type for use with
@@ -52,6 +58,14 @@ func (b *InferenceModelSpecApplyConfiguration) WithCriticality(value v1alpha1.Cr
 	return b
 }
 
+// WithAllowCriticalityIncrease sets the AllowCriticalityIncrease field in the declarative configuration to the given value
+// and returns the receiver, so that objects can be built by chaining "With" function invocations.
+// If called multiple times, the AllowCriticalityIncrease field is set to the value of the last call.
+func (b *InferenceModelSpecApplyConfiguration) WithAllowCriticalityIncrease(value bool) *InferenceModelSpecApplyConfiguration {
+	b.AllowCriticalityIncrease = &value
+	return b
+}
+
 // WithTargetModels adds the given value to the TargetModels field in the declarative configuration
 // and returns the receiver, so that objects can be build by chaining "With" function invocations.
 // If called multiple times, values provided by each call will be appended to the TargetModels field.
@@ -65,6 +79,53 @@ func (b *InferenceModelSpecApplyConfiguration) WithTargetModels(values ...*Targe
 	return b
 }
 
+// WithRules adds the given value to the Rules field in the declarative configuration
+// and returns the receiver, so that objects can be build by chaining "With" function invocations.
+// If called multiple times, values provided by each call will be appended to the Rules field.
+func (b *InferenceModelSpecApplyConfiguration) WithRules(values ...*TargetModelRuleApplyConfiguration) *InferenceModelSpecApplyConfiguration {
+	for i := range values {
+		if values[i] == nil {
+			panic("nil value passed to WithRules")
+		}
+		b.Rules = append(b.Rules, *values[i])
+	}
+	return b
+}
+
+// WithFallbacks adds the given value to the Fallbacks field in the declarative configuration
+// and returns the receiver, so that objects can be build by chaining "With" function invocations.
+// If called multiple times, values provided by each call will be appended to the Fallbacks field.
+func (b *InferenceModelSpecApplyConfiguration) WithFallbacks(values ...string) *InferenceModelSpecApplyConfiguration {
+	for i := range values {
+		b.Fallbacks = append(b.Fallbacks, values[i])
+	}
+	return b
+}
+
+// WithRollout sets the Rollout field in the declarative configuration to the given value
+// and returns the receiver, so that objects can be built by chaining "With" function invocations.
+// If called multiple times, the Rollout field is set to the value of the last call.
+func (b *InferenceModelSpecApplyConfiguration) WithRollout(value *ModelRolloutApplyConfiguration) *InferenceModelSpecApplyConfiguration {
+	b.Rollout = value
+	return b
+}
+
+// WithQuota sets the Quota field in the declarative configuration to the given value
+// and returns the receiver, so that objects can be built by chaining "With" function invocations.
+// If called multiple times, the Quota field is set to the value of the last call.
+func (b *InferenceModelSpecApplyConfiguration) WithQuota(value *TokenQuotaApplyConfiguration) *InferenceModelSpecApplyConfiguration {
+	b.Quota = value
+	return b
+}
+
+// WithLimits sets the Limits field in the declarative configuration to the given value
+// and returns the receiver, so that objects can be built by chaining "With" function invocations.
+// If called multiple times, the Limits field is set to the value of the last call.
+func (b *InferenceModelSpecApplyConfiguration) WithLimits(value *RequestLimitsApplyConfiguration) *InferenceModelSpecApplyConfiguration {
+	b.Limits = value
+	return b
+}
+
 // WithPoolRef sets the PoolRef field in the declarative configuration to the given value
 // and returns the receiver, so that objects can be built by chaining "With" function invocations.
 // If called multiple times, the PoolRef field is set to the value of the last call.
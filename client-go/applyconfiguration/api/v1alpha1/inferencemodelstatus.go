@@ -25,6 +25,7 @@ import (
 // with apply.
 type InferenceModelStatusApplyConfiguration struct {
 	Conditions []v1.ConditionApplyConfiguration `json:"conditions,omitempty"`
+	Rollout    *RolloutStatusApplyConfiguration `json:"rollout,omitempty"`
 }
 
 // InferenceModelStatusApplyConfiguration constructs a declarative configuration of the InferenceModelStatus type for use with
@@ -45,3 +46,11 @@ func (b *InferenceModelStatusApplyConfiguration) WithConditions(values ...*v1.Co
 	}
 	return b
 }
+
+// WithRollout sets the Rollout field in the declarative configuration to the given value
+// and returns the receiver, so that objects can be built by chaining "With" function invocations.
+// If called multiple times, the Rollout field is set to the value of the last call.
+func (b *InferenceModelStatusApplyConfiguration) WithRollout(value *RolloutStatusApplyConfiguration) *InferenceModelStatusApplyConfiguration {
+	b.Rollout = value
+	return b
+}
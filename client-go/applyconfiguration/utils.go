@@ -43,10 +43,24 @@ func ForKind(kind schema.GroupVersionKind) interface{} {
 		return &apiv1alpha1.InferencePoolSpecApplyConfiguration{}
 	case v1alpha1.SchemeGroupVersion.WithKind("InferencePoolStatus"):
 		return &apiv1alpha1.InferencePoolStatusApplyConfiguration{}
+	case v1alpha1.SchemeGroupVersion.WithKind("ModelRollout"):
+		return &apiv1alpha1.ModelRolloutApplyConfiguration{}
 	case v1alpha1.SchemeGroupVersion.WithKind("PoolObjectReference"):
 		return &apiv1alpha1.PoolObjectReferenceApplyConfiguration{}
+	case v1alpha1.SchemeGroupVersion.WithKind("RequestLimits"):
+		return &apiv1alpha1.RequestLimitsApplyConfiguration{}
+	case v1alpha1.SchemeGroupVersion.WithKind("RequestMatch"):
+		return &apiv1alpha1.RequestMatchApplyConfiguration{}
+	case v1alpha1.SchemeGroupVersion.WithKind("RolloutStatus"):
+		return &apiv1alpha1.RolloutStatusApplyConfiguration{}
+	case v1alpha1.SchemeGroupVersion.WithKind("RolloutStep"):
+		return &apiv1alpha1.RolloutStepApplyConfiguration{}
 	case v1alpha1.SchemeGroupVersion.WithKind("TargetModel"):
 		return &apiv1alpha1.TargetModelApplyConfiguration{}
+	case v1alpha1.SchemeGroupVersion.WithKind("TargetModelRule"):
+		return &apiv1alpha1.TargetModelRuleApplyConfiguration{}
+	case v1alpha1.SchemeGroupVersion.WithKind("TokenQuota"):
+		return &apiv1alpha1.TokenQuotaApplyConfiguration{}
 
 	}
 	return nil
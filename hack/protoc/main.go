@@ -0,0 +1,143 @@
+//go:build tools
+
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command protoc compiles the protos of the API and runs the protoc plugins on them, like protoc.
+// The imports which are not in the API are resolved from the Go packages registering them, since
+// the Go modules of the dependencies, such as the ORCA load report, do not have their .proto files.
+//
+// Usage: go run -tags tools ./hack/protoc -I api -plugin go -plugin go-grpc -out api FILE...
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	// The imports of the protos of the API.
+	_ "github.com/cncf/xds/go/xds/data/orca/v3"
+	_ "google.golang.org/protobuf/types/known/emptypb"
+)
+
+type stringsFlag []string
+
+func (f *stringsFlag) String() string     { return strings.Join(*f, ",") }
+func (f *stringsFlag) Set(v string) error { *f = append(*f, v); return nil }
+
+func main() {
+	var importPaths, plugins stringsFlag
+	flag.Var(&importPaths, "I", "directory the protos and their imports are searched in")
+	flag.Var(&plugins, "plugin", "name of a plugin, run as protoc-gen-NAME")
+	out := flag.String("out", ".", "directory the generated files are written to")
+	opt := flag.String("opt", "paths=source_relative", "parameter passed to the plugins")
+	flag.Parse()
+	if err := run(importPaths, plugins, *out, *opt, flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "protoc: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(importPaths, plugins []string, out, opt string, names []string) error {
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.CompositeResolver{
+			&protocompile.SourceResolver{ImportPaths: importPaths},
+			protocompile.ResolverFunc(func(path string) (protocompile.SearchResult, error) {
+				desc, err := protoregistry.GlobalFiles.FindFileByPath(path)
+				if err != nil {
+					return protocompile.SearchResult{}, fmt.Errorf("%s: %w", path, err)
+				}
+				return protocompile.SearchResult{Desc: desc}, nil
+			}),
+		},
+		SourceInfoMode: protocompile.SourceInfoStandard,
+	}
+	files, err := compiler.Compile(context.Background(), names...)
+	if err != nil {
+		return err
+	}
+	req := &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: names,
+		Parameter:      proto.String(opt),
+	}
+	// The files are listed after their imports.
+	seen := make(map[string]bool)
+	var add func(protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if seen[fd.Path()] {
+			return
+		}
+		seen[fd.Path()] = true
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			add(imports.Get(i).FileDescriptor)
+		}
+		req.ProtoFile = append(req.ProtoFile, protodesc.ToFileDescriptorProto(fd))
+	}
+	for _, fd := range files {
+		add(fd)
+	}
+	for _, plugin := range plugins {
+		if err := runPlugin(plugin, req, out); err != nil {
+			return fmt.Errorf("protoc-gen-%s: %w", plugin, err)
+		}
+	}
+	return nil
+}
+
+func runPlugin(plugin string, req *pluginpb.CodeGeneratorRequest, out string) error {
+	in, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	var stdout bytes.Buffer
+	cmd := exec.Command("protoc-gen-" + plugin)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	resp := &pluginpb.CodeGeneratorResponse{}
+	if err := proto.Unmarshal(stdout.Bytes(), resp); err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s", resp.GetError())
+	}
+	for _, f := range resp.File {
+		name := filepath.Join(out, f.GetName())
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(name, []byte(f.GetContent()), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package backend
 
 import (
 	"fmt"
+	"hash/fnv"
 	"math/rand"
 	"sync"
 
@@ -67,6 +68,16 @@ func (ds *K8sDatastore) GetPodIPs() []string {
 	return ips
 }
 
+// AllInferenceModels returns the InferenceModels referencing the InferencePool.
+func (ds *K8sDatastore) AllInferenceModels() []*v1alpha1.InferenceModel {
+	var models []*v1alpha1.InferenceModel
+	ds.InferenceModels.Range(func(name, model any) bool {
+		models = append(models, model.(*v1alpha1.InferenceModel))
+		return true
+	})
+	return models
+}
+
 func (s *K8sDatastore) FetchModelData(modelName string) (returnModel *v1alpha1.InferenceModel) {
 	infModel, ok := s.InferenceModels.Load(modelName)
 	if ok {
@@ -75,31 +86,140 @@ func (s *K8sDatastore) FetchModelData(modelName string) (returnModel *v1alpha1.I
 	return
 }
 
+// RandomWeightedDraw draws one of the target models of the model at random, proportionally to their
+// weights. A positive seed makes the draw deterministic, e.g. for tests. It returns an empty string
+// if the model has no target model with a positive weight.
 func RandomWeightedDraw(model *v1alpha1.InferenceModel, seed int64) string {
-	var weights int32
-
-	source := rand.NewSource(rand.Int63())
+	targetModels := EffectiveTargetModels(model)
+	weights := totalWeight(targetModels)
+	klog.V(3).Infof("Weights for Model(%v) total to: %v", model.Name, weights)
+	if weights <= 0 {
+		return ""
+	}
+	// The total weight fits in an int32, see EffectiveTargetModels.
+	var randomVal int32
 	if seed > 0 {
-		source = rand.NewSource(seed)
+		randomVal = rand.New(rand.NewSource(seed)).Int31n(int32(weights))
+	} else {
+		// The top-level functions use a shared source which is safe for concurrent use, avoiding the
+		// allocation and seeding of a source per request.
+		randomVal = rand.Int31n(int32(weights))
+	}
+	return pickTargetModel(targetModels, int64(randomVal))
+}
+
+// HashWeightedDraw deterministically picks one of the target models of the model by hashing the key
+// into the weight space, so that requests with the same key are sent to the same target model as
+// long as the weights don't change. It returns an empty string if the model has no target model
+// with a positive weight.
+func HashWeightedDraw(model *v1alpha1.InferenceModel, key string) string {
+	targetModels := EffectiveTargetModels(model)
+	weights := totalWeight(targetModels)
+	if weights <= 0 {
+		return ""
 	}
-	r := rand.New(source)
-	for _, model := range model.Spec.TargetModels {
-		weights += model.Weight
+	h := fnv.New64a()
+	// Hash the model name too so that the assignments of a key to the target models of different
+	// models are independent.
+	h.Write([]byte(model.Spec.ModelName))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return pickTargetModel(targetModels, int64(h.Sum64()%uint64(weights)))
+}
+
+func totalWeight(targetModels []v1alpha1.TargetModel) int64 {
+	var weights int64
+	for _, tm := range targetModels {
+		weights += int64(tm.Weight)
 	}
-	klog.V(3).Infof("Weights for Model(%v) total to: %v", model.Name, weights)
-	randomVal := r.Int31n(weights)
-	for _, model := range model.Spec.TargetModels {
-		if randomVal < model.Weight {
-			return model.Name
+	return weights
+}
+
+// pickTargetModel returns the target model val falls into, with val in [0, total weight).
+func pickTargetModel(targetModels []v1alpha1.TargetModel, val int64) string {
+	for _, tm := range targetModels {
+		if val < int64(tm.Weight) {
+			return tm.Name
 		}
-		randomVal -= model.Weight
+		val -= int64(tm.Weight)
 	}
 	return ""
 }
 
-func IsCritical(model *v1alpha1.InferenceModel) bool {
-	if model.Spec.Criticality != nil && *model.Spec.Criticality == v1alpha1.Critical {
-		return true
+// EffectiveTargetModels returns the target models of the model with the weights adjusted for the
+// rollout in progress, if any. The rollout target model receives the percentage of the traffic
+// recorded in the rollout status, and the other target models share the rest according to their
+// weights. Without a rollout, or if the rollout target is not one of the target models, the
+// target models are returned as is.
+func EffectiveTargetModels(model *v1alpha1.InferenceModel) []v1alpha1.TargetModel {
+	rollout, rolloutStatus := model.Spec.Rollout, model.Status.Rollout
+	if rollout == nil || rolloutStatus == nil || rolloutStatus.TargetModel != rollout.TargetModel {
+		return model.Spec.TargetModels
+	}
+	var othersWeight int32
+	found := false
+	for _, tm := range model.Spec.TargetModels {
+		if tm.Name == rollout.TargetModel {
+			found = true
+		} else {
+			othersWeight += tm.Weight
+		}
+	}
+	if !found {
+		return model.Spec.TargetModels
+	}
+	percent := rolloutStatus.Percent
+	if rolloutStatus.Phase == v1alpha1.RolloutRolledBack {
+		percent = 0
+	}
+	targetModels := make([]v1alpha1.TargetModel, 0, len(model.Spec.TargetModels))
+	for _, tm := range model.Spec.TargetModels {
+		// Scale the weights so that the rollout target model weighs percent/100 of the total. With
+		// weights of at most 1000000 and at most 10 target models, this does not overflow.
+		switch {
+		case tm.Name == rollout.TargetModel && othersWeight == 0:
+			tm.Weight = 1
+		case tm.Name == rollout.TargetModel:
+			tm.Weight = percent * othersWeight
+		default:
+			tm.Weight = tm.Weight * (100 - percent)
+		}
+		targetModels = append(targetModels, tm)
+	}
+	return targetModels
+}
+
+// GetCriticality returns the criticality of the model, falling back to v1alpha1.Default when it is
+// not set, which matches the API default.
+func GetCriticality(model *v1alpha1.InferenceModel) v1alpha1.Criticality {
+	if model.Spec.Criticality != nil {
+		return *model.Spec.Criticality
+	}
+	return v1alpha1.Default
+}
+
+// criticalityRank orders criticalities from the least to the most important.
+var criticalityRank = map[v1alpha1.Criticality]int{
+	v1alpha1.Sheddable: 0,
+	v1alpha1.Default:   1,
+	v1alpha1.Critical:  2,
+}
+
+// ResolveCriticality returns the criticality of a request to the model given the criticality
+// requested by the client. Requests may always lower their criticality, but may only raise it if
+// the model allows it. An empty or unknown requested criticality is ignored.
+func ResolveCriticality(model *v1alpha1.InferenceModel, requested v1alpha1.Criticality) v1alpha1.Criticality {
+	modelCriticality := GetCriticality(model)
+	requestedRank, ok := criticalityRank[requested]
+	if !ok {
+		return modelCriticality
+	}
+	if requestedRank <= criticalityRank[modelCriticality] {
+		return requested
+	}
+	if model.Spec.AllowCriticalityIncrease != nil && *model.Spec.AllowCriticalityIncrease {
+		return requested
 	}
-	return false
+	klog.V(3).Infof("Ignoring requested criticality %v for model %v, which does not allow raising its criticality %v", requested, model.Name, modelCriticality)
+	return modelCriticality
 }
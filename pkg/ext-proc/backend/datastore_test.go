@@ -1,8 +1,10 @@
 package backend
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
 )
 
@@ -88,3 +90,235 @@ func TestRandomWeightedDraw(t *testing.T) {
 		})
 	}
 }
+
+func TestRandomWeightedDrawZeroWeights(t *testing.T) {
+	model := &v1alpha1.InferenceModel{
+		Spec: v1alpha1.InferenceModelSpec{
+			TargetModels: []v1alpha1.TargetModel{{Name: "reserved", Weight: 0}},
+		},
+	}
+	if got := RandomWeightedDraw(model, 0); got != "" {
+		t.Errorf("RandomWeightedDraw() = %q, want no target model", got)
+	}
+	if got := HashWeightedDraw(model, "user"); got != "" {
+		t.Errorf("HashWeightedDraw() = %q, want no target model", got)
+	}
+}
+
+func TestHashWeightedDraw(t *testing.T) {
+	model := &v1alpha1.InferenceModel{
+		Spec: v1alpha1.InferenceModelSpec{
+			ModelName: "my-model",
+			TargetModels: []v1alpha1.TargetModel{
+				{Name: "v1", Weight: 75},
+				{Name: "v2", Weight: 25},
+				{Name: "reserved", Weight: 0},
+			},
+		},
+	}
+	counts := make(map[string]int)
+	for i := range 10000 {
+		key := fmt.Sprintf("user-%d", i)
+		got := HashWeightedDraw(model, key)
+		for range 3 {
+			if again := HashWeightedDraw(model, key); again != got {
+				t.Fatalf("HashWeightedDraw(%q) is not stable: %q then %q", key, got, again)
+			}
+		}
+		counts[got]++
+	}
+	if counts["reserved"] != 0 {
+		t.Errorf("Target model with zero weight was picked %d times", counts["reserved"])
+	}
+	// The split should be close to the weights.
+	if counts["v1"] < 7000 || counts["v1"] > 8000 {
+		t.Errorf("Unexpected split: %v", counts)
+	}
+}
+
+func TestEffectiveTargetModels(t *testing.T) {
+	targetModels := []v1alpha1.TargetModel{
+		{Name: "v1", Weight: 30},
+		{Name: "v1.1", Weight: 10},
+		{Name: "v2", Weight: 1},
+	}
+	rollout := &v1alpha1.ModelRollout{
+		TargetModel: "v2",
+		Steps:       []v1alpha1.RolloutStep{{Percent: 20}, {Percent: 50}},
+	}
+	tests := []struct {
+		name   string
+		spec   v1alpha1.InferenceModelSpec
+		status v1alpha1.InferenceModelStatus
+		want   []v1alpha1.TargetModel
+	}{
+		{
+			name: "no rollout",
+			spec: v1alpha1.InferenceModelSpec{TargetModels: targetModels},
+			want: targetModels,
+		},
+		{
+			name: "rollout not started",
+			spec: v1alpha1.InferenceModelSpec{TargetModels: targetModels, Rollout: rollout},
+			want: targetModels,
+		},
+		{
+			name: "rollout in progress",
+			spec: v1alpha1.InferenceModelSpec{TargetModels: targetModels, Rollout: rollout},
+			status: v1alpha1.InferenceModelStatus{Rollout: &v1alpha1.RolloutStatus{
+				TargetModel: "v2",
+				Phase:       v1alpha1.RolloutProgressing,
+				Percent:     20,
+			}},
+			want: []v1alpha1.TargetModel{
+				{Name: "v1", Weight: 2400},
+				{Name: "v1.1", Weight: 800},
+				{Name: "v2", Weight: 800},
+			},
+		},
+		{
+			name: "rollout rolled back",
+			spec: v1alpha1.InferenceModelSpec{TargetModels: targetModels, Rollout: rollout},
+			status: v1alpha1.InferenceModelStatus{Rollout: &v1alpha1.RolloutStatus{
+				TargetModel: "v2",
+				Phase:       v1alpha1.RolloutRolledBack,
+				Percent:     20,
+			}},
+			want: []v1alpha1.TargetModel{
+				{Name: "v1", Weight: 3000},
+				{Name: "v1.1", Weight: 1000},
+				{Name: "v2", Weight: 0},
+			},
+		},
+		{
+			name: "status of a previous rollout",
+			spec: v1alpha1.InferenceModelSpec{TargetModels: targetModels, Rollout: rollout},
+			status: v1alpha1.InferenceModelStatus{Rollout: &v1alpha1.RolloutStatus{
+				TargetModel: "v1.1",
+				Phase:       v1alpha1.RolloutSucceeded,
+				Percent:     100,
+			}},
+			want: targetModels,
+		},
+		{
+			name: "rollout target is the only target model",
+			spec: v1alpha1.InferenceModelSpec{
+				TargetModels: []v1alpha1.TargetModel{{Name: "v2", Weight: 1}},
+				Rollout:      rollout,
+			},
+			status: v1alpha1.InferenceModelStatus{Rollout: &v1alpha1.RolloutStatus{
+				TargetModel: "v2",
+				Phase:       v1alpha1.RolloutProgressing,
+				Percent:     20,
+			}},
+			want: []v1alpha1.TargetModel{{Name: "v2", Weight: 1}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			model := &v1alpha1.InferenceModel{Spec: test.spec, Status: test.status}
+			got := EffectiveTargetModels(model)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Unexpected output (-want +got): %v", diff)
+			}
+		})
+	}
+}
+
+func TestGetCriticality(t *testing.T) {
+	critical := v1alpha1.Critical
+	sheddable := v1alpha1.Sheddable
+	tests := []struct {
+		name  string
+		model *v1alpha1.InferenceModel
+		want  v1alpha1.Criticality
+	}{
+		{
+			name:  "unset defaults to Default",
+			model: &v1alpha1.InferenceModel{},
+			want:  v1alpha1.Default,
+		},
+		{
+			name: "critical",
+			model: &v1alpha1.InferenceModel{
+				Spec: v1alpha1.InferenceModelSpec{Criticality: &critical},
+			},
+			want: v1alpha1.Critical,
+		},
+		{
+			name: "sheddable",
+			model: &v1alpha1.InferenceModel{
+				Spec: v1alpha1.InferenceModelSpec{Criticality: &sheddable},
+			},
+			want: v1alpha1.Sheddable,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := GetCriticality(test.model); got != test.want {
+				t.Errorf("GetCriticality() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestResolveCriticality(t *testing.T) {
+	defaultCriticality := v1alpha1.Default
+	allow := true
+	tests := []struct {
+		name      string
+		model     *v1alpha1.InferenceModel
+		requested v1alpha1.Criticality
+		want      v1alpha1.Criticality
+	}{
+		{
+			name: "no override",
+			model: &v1alpha1.InferenceModel{
+				Spec: v1alpha1.InferenceModelSpec{Criticality: &defaultCriticality},
+			},
+			want: v1alpha1.Default,
+		},
+		{
+			name: "unknown override is ignored",
+			model: &v1alpha1.InferenceModel{
+				Spec: v1alpha1.InferenceModelSpec{Criticality: &defaultCriticality},
+			},
+			requested: "Urgent",
+			want:      v1alpha1.Default,
+		},
+		{
+			name: "lowering is always allowed",
+			model: &v1alpha1.InferenceModel{
+				Spec: v1alpha1.InferenceModelSpec{Criticality: &defaultCriticality},
+			},
+			requested: v1alpha1.Sheddable,
+			want:      v1alpha1.Sheddable,
+		},
+		{
+			name: "raising is not allowed by default",
+			model: &v1alpha1.InferenceModel{
+				Spec: v1alpha1.InferenceModelSpec{Criticality: &defaultCriticality},
+			},
+			requested: v1alpha1.Critical,
+			want:      v1alpha1.Default,
+		},
+		{
+			name: "raising is allowed by the model",
+			model: &v1alpha1.InferenceModel{
+				Spec: v1alpha1.InferenceModelSpec{
+					Criticality:              &defaultCriticality,
+					AllowCriticalityIncrease: &allow,
+				},
+			},
+			requested: v1alpha1.Critical,
+			want:      v1alpha1.Critical,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ResolveCriticality(test.model, test.requested); got != test.want {
+				t.Errorf("ResolveCriticality() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package backend
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// historySize is the number of recent metrics of each pod the trends are computed over.
+	historySize = 16
+	// ewmaTimeConstant is the time constant of the exponentially weighted moving averages of the
+	// metrics. The weight of a sample decays by e every time constant.
+	ewmaTimeConstant = time.Second
+	// minSampleInterval is the minimum interval between the samples the trends are computed over.
+	// A sample closer to the previous one replaces the latest sample, so that bursts of metrics,
+	// such as the load reports of many responses, do not fill the history.
+	minSampleInterval = ewmaTimeConstant / 8
+	// minSlopeSpan is the minimum time span of the samples for their slopes to be computed, since
+	// the slopes of closely spaced samples are dominated by noise.
+	minSlopeSpan = ewmaTimeConstant
+)
+
+// Trend is the smoothed values and the trends of the metrics of a pod, computed over its recent
+// metrics.
+type Trend struct {
+	// WaitingQueueSizeEWMA is the exponentially weighted moving average of the waiting queue size.
+	WaitingQueueSizeEWMA float64
+	// WaitingQueueSizeSlope is the growth of the waiting queue size, in requests per second.
+	WaitingQueueSizeSlope float64
+	// KVCacheUsageEWMA is the exponentially weighted moving average of the KV cache usage.
+	KVCacheUsageEWMA float64
+	// KVCacheUsageSlope is the growth of the KV cache usage, as a fraction of the KV cache per
+	// second.
+	KVCacheUsageSlope float64
+}
+
+// sample is the metrics of a pod at a point in time.
+type sample struct {
+	time             time.Time
+	waitingQueueSize float64
+	kvCacheUsage     float64
+}
+
+// metricsHistory is a ring buffer of the recent metrics of a pod.
+type metricsHistory struct {
+	mu      sync.Mutex
+	samples [historySize]sample
+	// next is the index of the next sample, and n the number of samples.
+	next, n int
+	ewma    Trend
+}
+
+// add records the metrics at the time, and returns the trend of the metrics including them.
+func (h *metricsHistory) add(now time.Time, m *Metrics) Trend {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := sample{time: now, waitingQueueSize: float64(m.WaitingQueueSize), kvCacheUsage: m.KVCacheUsagePercent}
+	if h.n == 0 {
+		h.ewma.WaitingQueueSizeEWMA = s.waitingQueueSize
+		h.ewma.KVCacheUsageEWMA = s.kvCacheUsage
+	} else {
+		last := h.samples[(h.next+historySize-1)%historySize]
+		// The weight of the new sample depends on the time since the last one, since the metrics
+		// are not sampled at a fixed interval.
+		alpha := 1 - math.Exp(-float64(now.Sub(last.time))/float64(ewmaTimeConstant))
+		h.ewma.WaitingQueueSizeEWMA += alpha * (s.waitingQueueSize - h.ewma.WaitingQueueSizeEWMA)
+		h.ewma.KVCacheUsageEWMA += alpha * (s.kvCacheUsage - h.ewma.KVCacheUsageEWMA)
+	}
+	if h.n >= 2 && now.Sub(h.samples[(h.next+historySize-2)%historySize].time) < minSampleInterval {
+		// The latest sample is too close to the previous one, and is replaced.
+		h.samples[(h.next+historySize-1)%historySize] = s
+	} else {
+		h.samples[h.next] = s
+		h.next = (h.next + 1) % historySize
+		h.n = min(h.n+1, historySize)
+	}
+
+	trend := h.ewma
+	trend.WaitingQueueSizeSlope, trend.KVCacheUsageSlope = h.slopes()
+	return trend
+}
+
+// slopes returns the least squares slopes, per second, of the waiting queue size and the KV cache
+// usage over the samples, or zero until the samples span minSlopeSpan.
+func (h *metricsHistory) slopes() (float64, float64) {
+	if h.n < 2 {
+		return 0, 0
+	}
+	origin := h.samples[(h.next+historySize-h.n)%historySize].time
+	if h.samples[(h.next+historySize-1)%historySize].time.Sub(origin) < minSlopeSpan {
+		return 0, 0
+	}
+	var sumT, sumQ, sumKV float64
+	for i := 0; i < h.n; i++ {
+		s := h.samples[(h.next+historySize-h.n+i)%historySize]
+		sumT += s.time.Sub(origin).Seconds()
+		sumQ += s.waitingQueueSize
+		sumKV += s.kvCacheUsage
+	}
+	n := float64(h.n)
+	meanT, meanQ, meanKV := sumT/n, sumQ/n, sumKV/n
+	var varT, covQ, covKV float64
+	for i := 0; i < h.n; i++ {
+		s := h.samples[(h.next+historySize-h.n+i)%historySize]
+		dt := s.time.Sub(origin).Seconds() - meanT
+		varT += dt * dt
+		covQ += dt * (s.waitingQueueSize - meanQ)
+		covKV += dt * (s.kvCacheUsage - meanKV)
+	}
+	if varT == 0 {
+		return 0, 0
+	}
+	return covQ / varT, covKV / varT
+}
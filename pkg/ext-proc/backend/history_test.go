@@ -0,0 +1,67 @@
+package backend
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMetricsHistory(t *testing.T) {
+	start := time.Unix(0, 0)
+	h := &metricsHistory{}
+	got := h.add(start, &Metrics{WaitingQueueSize: 2, KVCacheUsagePercent: 0.1})
+	want := Trend{WaitingQueueSizeEWMA: 2, KVCacheUsageEWMA: 0.1}
+	if got != want {
+		t.Errorf("Unexpected trend of the first sample, got %+v, want %+v", got, want)
+	}
+
+	// The KV cache usage grows by 0.1 per second, and the queue stays constant.
+	for i := 1; i <= 2*historySize; i++ {
+		got = h.add(start.Add(time.Duration(i)*100*time.Millisecond), &Metrics{
+			WaitingQueueSize:    2,
+			KVCacheUsagePercent: 0.1 + 0.01*float64(i),
+		})
+	}
+	if !approxEqual(got.KVCacheUsageSlope, 0.1) || !approxEqual(got.WaitingQueueSizeSlope, 0) {
+		t.Errorf("Unexpected slopes %+v, want 0.1 and 0", got)
+	}
+	if !approxEqual(got.WaitingQueueSizeEWMA, 2) {
+		t.Errorf("Unexpected waiting queue size EWMA %v, want 2", got.WaitingQueueSizeEWMA)
+	}
+	// The average lags behind the growing KV cache usage.
+	if latest := 0.1 + 0.01*2*historySize; got.KVCacheUsageEWMA >= latest || got.KVCacheUsageEWMA <= 0.1 {
+		t.Errorf("Unexpected KV cache usage EWMA %v, want between 0.1 and %v", got.KVCacheUsageEWMA, latest)
+	}
+
+	// Samples at the same time have no slope.
+	h = &metricsHistory{}
+	h.add(start, &Metrics{KVCacheUsagePercent: 0.1})
+	if got := h.add(start, &Metrics{KVCacheUsagePercent: 0.5}); got.KVCacheUsageSlope != 0 {
+		t.Errorf("Unexpected slope %v of samples at the same time, want 0", got.KVCacheUsageSlope)
+	}
+
+	// Bursts of closely spaced samples have no slope until the samples span minSlopeSpan, and do
+	// not push the older samples out of the history.
+	h = &metricsHistory{}
+	for i := 0; i < 4*historySize; i++ {
+		kv := 0.1
+		if i%2 == 1 {
+			kv = 0.9
+		}
+		got = h.add(start.Add(time.Duration(i)*time.Millisecond), &Metrics{KVCacheUsagePercent: kv})
+		if got.KVCacheUsageSlope != 0 {
+			t.Fatalf("Unexpected slope %v of a burst of %d samples, want 0", got.KVCacheUsageSlope, i+1)
+		}
+	}
+	if h.n != 2 {
+		t.Errorf("Unexpected number of samples %d after a burst, want 2", h.n)
+	}
+	got = h.add(start.Add(minSlopeSpan), &Metrics{KVCacheUsagePercent: 0.9})
+	if got.KVCacheUsageSlope <= 0 {
+		t.Errorf("Unexpected slope %v once the samples span minSlopeSpan, want positive", got.KVCacheUsageSlope)
+	}
+}
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
@@ -0,0 +1,97 @@
+// Package loadreport implements a gRPC service receiving the ORCA load reports pushed by the model
+// servers, e.g. by a sidecar, so that their metrics are not polled.
+package loadreport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+
+	orcav3 "github.com/cncf/xds/go/xds/data/orca/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	klog "k8s.io/klog/v2"
+
+	loadreportv1alpha1 "inference.networking.x-k8s.io/llm-instance-gateway/api/loadreport/v1alpha1"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+)
+
+// PodNameHeader is the metadata key of the name of the pod whose load is reported on a stream.
+const PodNameHeader = "x-pod-name"
+
+// Provider is the part of the backend.Provider the load reports are pushed to.
+type Provider interface {
+	FindPod(name string) (backend.Pod, bool)
+	ApplyLoadReport(pod backend.Pod, report *orcav3.OrcaLoadReport)
+}
+
+// Server receives the load reports of the model servers.
+type Server struct {
+	loadreportv1alpha1.UnimplementedLoadReportServiceServer
+	provider Provider
+}
+
+// Register registers the load report service on the gRPC server.
+func Register(s *grpc.Server, provider Provider) {
+	loadreportv1alpha1.RegisterLoadReportServiceServer(s, &Server{provider: provider})
+}
+
+// StreamLoadReports applies the load reports of a pod, named by the PodNameHeader metadata, to its
+// metrics until the pod closes the stream. The stream must come from the address of the pod, so
+// that a client cannot report the load of another pod.
+func (s *Server) StreamLoadReports(stream loadreportv1alpha1.LoadReportService_StreamLoadReportsServer) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	names := md.Get(PodNameHeader)
+	if len(names) != 1 || names[0] == "" {
+		return status.Errorf(codes.InvalidArgument, "the %q metadata must name the pod", PodNameHeader)
+	}
+	pod, ok := s.provider.FindPod(names[0])
+	if !ok {
+		return status.Errorf(codes.NotFound, "pod %q not found", names[0])
+	}
+	if !fromPod(stream.Context(), pod) {
+		return status.Errorf(codes.PermissionDenied, "load reports of pod %q must be sent from its address", names[0])
+	}
+	klog.V(2).Infof("Receiving load reports of pod %v", pod)
+	for {
+		report, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return stream.SendAndClose(&emptypb.Empty{})
+			}
+			return err
+		}
+		klog.V(4).Infof("Load report of pod %v: %v", pod, report)
+		s.provider.ApplyLoadReport(pod, report)
+	}
+}
+
+// fromPod returns whether the peer of the stream has the IP address of the pod.
+func fromPod(ctx context.Context, pod backend.Pod) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	addr, ok := p.Addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	host, _, err := net.SplitHostPort(pod.Address)
+	if err != nil {
+		host = pod.Address
+	}
+	podIP := net.ParseIP(host)
+	return podIP != nil && podIP.Equal(addr.IP)
+}
+
+// NewStream opens a stream pushing the load reports of the pod to the ext-proc on the connection,
+// e.g. from a sidecar of the model server.
+func NewStream(ctx context.Context, cc grpc.ClientConnInterface, podName string) (loadreportv1alpha1.LoadReportService_StreamLoadReportsClient, error) {
+	ctx = metadata.AppendToOutgoingContext(ctx, PodNameHeader, podName)
+	return loadreportv1alpha1.NewLoadReportServiceClient(cc).StreamLoadReports(ctx)
+}
@@ -0,0 +1,103 @@
+package loadreport
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	orcav3 "github.com/cncf/xds/go/xds/data/orca/v3"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+)
+
+type fakeProvider struct {
+	mu      sync.Mutex
+	pods    map[string]backend.Pod
+	reports map[backend.Pod][]*orcav3.OrcaLoadReport
+}
+
+func (p *fakeProvider) FindPod(name string) (backend.Pod, bool) {
+	pod, ok := p.pods[name]
+	return pod, ok
+}
+
+func (p *fakeProvider) ApplyLoadReport(pod backend.Pod, report *orcav3.OrcaLoadReport) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reports[pod] = append(p.reports[pod], report)
+}
+
+func TestStreamLoadReports(t *testing.T) {
+	// The test client connects from the loopback address, like the sidecar of a pod would from the
+	// pod address.
+	pod := backend.Pod{Name: "pod1", Address: "127.0.0.1:8000"}
+	other := backend.Pod{Name: "pod2", Address: "10.0.0.2:8000"}
+	provider := &fakeProvider{
+		pods:    map[string]backend.Pod{pod.Name: pod, other.Name: other},
+		reports: make(map[backend.Pod][]*orcav3.OrcaLoadReport),
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	s := grpc.NewServer()
+	Register(s, provider)
+	go s.Serve(lis)
+	defer s.Stop()
+	cc, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer cc.Close()
+
+	reports := []*orcav3.OrcaLoadReport{
+		{NamedMetrics: map[string]float64{backend.ORCAWaitingQueueSize: 1}},
+		{Utilization: map[string]float64{backend.ORCAKVCacheUtilization: 0.5}},
+	}
+	stream, err := NewStream(context.Background(), cc, pod.Name)
+	if err != nil {
+		t.Fatalf("NewStream: %v", err)
+	}
+	for _, r := range reports {
+		if err := stream.Send(r); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	if _, err := stream.CloseAndRecv(); err != nil {
+		t.Fatalf("CloseAndRecv: %v", err)
+	}
+	if diff := cmp.Diff(reports, provider.reports[pod], protocmp.Transform()); diff != "" {
+		t.Errorf("Unexpected reports (-want +got): %v", diff)
+	}
+
+	stream, err = NewStream(context.Background(), cc, "unknown")
+	if err != nil {
+		t.Fatalf("NewStream: %v", err)
+	}
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.NotFound {
+		t.Errorf("Unexpected error for an unknown pod, got %v, want NotFound", err)
+	}
+
+	// Another pod cannot be reported from this address.
+	stream, err = NewStream(context.Background(), cc, other.Name)
+	if err != nil {
+		t.Fatalf("NewStream: %v", err)
+	}
+	if err := stream.Send(reports[0]); err != nil && err != io.EOF {
+		t.Fatalf("Send: %v", err)
+	}
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Unexpected error for another pod, got %v, want PermissionDenied", err)
+	}
+	if len(provider.reports[other]) != 0 {
+		t.Errorf("Unexpected reports of another pod: %v", provider.reports[other])
+	}
+}
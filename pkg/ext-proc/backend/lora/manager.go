@@ -0,0 +1,367 @@
+// Package lora orchestrates which LoRA adapters are loaded on which model servers, based on the
+// target models of the InferenceModels and the observed demand for each of them.
+package lora
+
+import (
+	"context"
+	"math"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+	klog "k8s.io/klog/v2"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+)
+
+const (
+	// demandDecay is the factor applied to the demand of an adapter at every reconciliation, so that
+	// the demand reflects the recent requests.
+	demandDecay = 0.5
+	// minDemand is the demand below which an adapter is considered unused.
+	minDemand = 0.01
+	// reconcileTimeout bounds the time spent loading and unloading adapters in one reconciliation.
+	reconcileTimeout = 30 * time.Second
+)
+
+// AdapterClient loads and unloads LoRA adapters on model servers.
+type AdapterClient interface {
+	LoadAdapter(ctx context.Context, pod backend.Pod, name, source string) error
+	UnloadAdapter(ctx context.Context, pod backend.Pod, name string) error
+}
+
+// PodMetricsProvider provides the pods and their metrics, including the active adapters.
+type PodMetricsProvider interface {
+	AllPodMetrics() []*backend.PodMetrics
+}
+
+// ModelLister lists the InferenceModels served by the pool.
+type ModelLister interface {
+	AllInferenceModels() []*v1alpha1.InferenceModel
+}
+
+type Config struct {
+	// SourceDir is the directory adapters are loaded from, an adapter is loaded from
+	// SourceDir/<adapter name>. If empty, the adapter name is used as the source, e.g. to load it
+	// from Hugging Face.
+	SourceDir string
+	// BaseModels are the target models served by base models instead of LoRA adapters. They are
+	// never loaded or unloaded.
+	BaseModels []string
+	// MaxActionsPerReconcile limits the number of adapters loaded and unloaded in one
+	// reconciliation to avoid churn.
+	MaxActionsPerReconcile int
+}
+
+func NewManager(pmp PodMetricsProvider, models ModelLister, client AdapterClient, config Config) *Manager {
+	baseModels := make(map[string]bool, len(config.BaseModels))
+	for _, m := range config.BaseModels {
+		baseModels[m] = true
+	}
+	return &Manager{
+		pmp:        pmp,
+		models:     models,
+		client:     client,
+		config:     config,
+		baseModels: baseModels,
+		requests:   make(map[string]int),
+		demand:     make(map[string]float64),
+		placed:     make(map[backend.Pod]map[string]bool),
+	}
+}
+
+// Manager places LoRA adapters on model servers. Every adapter referenced by an InferenceModel is
+// kept loaded on at least one model server, and adapters with more demand are loaded on more
+// servers. When a model server has no free adapter slot, the adapter with the least demand among
+// the ones not needed there is evicted, even if it was loaded by someone else. Adapters which are
+// no longer needed are only unloaded if the manager loaded them.
+type Manager struct {
+	pmp        PodMetricsProvider
+	models     ModelLister
+	client     AdapterClient
+	config     Config
+	baseModels map[string]bool
+
+	mu sync.Mutex
+	// requests is the number of requests per target model since the last reconciliation.
+	requests map[string]int
+	// demand is the exponentially decayed number of requests per target model.
+	demand map[string]float64
+	// placed is the adapters loaded by the manager on each pod. It is only accessed by the
+	// reconciliations, which do not run concurrently.
+	placed map[backend.Pod]map[string]bool
+}
+
+// RecordRequest records a request to the target model.
+func (m *Manager) RecordRequest(targetModel string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[targetModel]++
+}
+
+// Init periodically reconciles the adapters loaded on the model servers.
+func (m *Manager) Init(reconcileInterval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(reconcileInterval)
+			if err := m.reconcileOnce(); err != nil {
+				klog.Errorf("Failed to reconcile LoRA adapters: %v", err)
+			}
+		}
+	}()
+}
+
+func (m *Manager) reconcileOnce() error {
+	demand := m.updateDemand()
+	registered := make(map[string]bool)
+	for _, model := range m.models.AllInferenceModels() {
+		if len(model.Spec.TargetModels) == 0 {
+			registered[model.Spec.ModelName] = true
+		}
+		for _, target := range model.Spec.TargetModels {
+			registered[target.Name] = true
+		}
+		for _, rule := range model.Spec.Rules {
+			registered[rule.TargetModel] = true
+		}
+		for _, fallback := range model.Spec.Fallbacks {
+			registered[fallback] = true
+		}
+	}
+	for name := range m.baseModels {
+		delete(registered, name)
+		delete(demand, name)
+	}
+
+	pods := m.pmp.AllPodMetrics()
+	m.forgetRemovedPods(pods)
+	actions := plan(pods, registered, demand, m.placed, m.config.MaxActionsPerReconcile)
+	if len(actions) == 0 {
+		return nil
+	}
+	klog.V(2).Infof("Reconciling LoRA adapters: %v", actions)
+	ctx, cancel := context.WithTimeout(context.Background(), reconcileTimeout)
+	defer cancel()
+	var errs error
+	for _, a := range actions {
+		var err error
+		if a.load {
+			if err = m.client.LoadAdapter(ctx, a.pod, a.adapter, m.source(a.adapter)); err == nil {
+				if m.placed[a.pod] == nil {
+					m.placed[a.pod] = make(map[string]bool)
+				}
+				m.placed[a.pod][a.adapter] = true
+			}
+		} else {
+			if err = m.client.UnloadAdapter(ctx, a.pod, a.adapter); err == nil {
+				delete(m.placed[a.pod], a.adapter)
+			}
+		}
+		errs = multierr.Append(errs, err)
+	}
+	return errs
+}
+
+// forgetRemovedPods forgets the adapters placed on the pods which were removed.
+func (m *Manager) forgetRemovedPods(pods []*backend.PodMetrics) {
+	current := make(map[backend.Pod]bool, len(pods))
+	for _, pm := range pods {
+		current[pm.Pod] = true
+	}
+	for pod := range m.placed {
+		if !current[pod] {
+			delete(m.placed, pod)
+		}
+	}
+}
+
+// updateDemand folds the requests since the last reconciliation into the demand and returns a
+// copy of it.
+func (m *Manager) updateDemand() map[string]float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, d := range m.demand {
+		m.demand[name] = d * demandDecay
+	}
+	for name, n := range m.requests {
+		m.demand[name] += float64(n)
+	}
+	m.requests = make(map[string]int)
+	res := make(map[string]float64, len(m.demand))
+	for name, d := range m.demand {
+		if d < minDemand {
+			delete(m.demand, name)
+			continue
+		}
+		res[name] = d
+	}
+	return res
+}
+
+func (m *Manager) source(adapter string) string {
+	if m.config.SourceDir == "" {
+		return adapter
+	}
+	return path.Join(m.config.SourceDir, adapter)
+}
+
+// action loads or unloads an adapter on a pod.
+type action struct {
+	load    bool
+	pod     backend.Pod
+	adapter string
+}
+
+func (a action) String() string {
+	if a.load {
+		return "load " + a.adapter + " on " + a.pod.String()
+	}
+	return "unload " + a.adapter + " from " + a.pod.String()
+}
+
+// podState is the planned state of a pod.
+type podState struct {
+	pm       *backend.PodMetrics
+	adapters map[string]bool
+}
+
+func (p *podState) hasFreeSlot() bool {
+	return len(p.adapters) < p.pm.MaxActiveModels
+}
+
+// plan computes the actions to converge the adapters loaded on the pods to the desired placement.
+// An adapter is wanted if it's registered in an InferenceModel or has recent demand. Every wanted
+// adapter should be loaded on at least one pod, and on a number of pods proportional to its share
+// of the total demand. Unwanted adapters and adapters loaded on more pods than desired are evicted
+// to make room for other adapters, and the remaining unwanted adapters are unloaded if they were
+// placed by the manager.
+func plan(pods []*backend.PodMetrics, registered map[string]bool, demand map[string]float64, placed map[backend.Pod]map[string]bool, maxActions int) []action {
+	var states []*podState
+	replicas := make(map[string]int)
+	for _, pm := range pods {
+		// Pods that don't report their adapter slots can't be managed.
+		if pm.MaxActiveModels == 0 {
+			continue
+		}
+		s := &podState{pm: pm, adapters: make(map[string]bool, len(pm.ActiveModels))}
+		for name := range pm.ActiveModels {
+			s.adapters[name] = true
+			replicas[name]++
+		}
+		states = append(states, s)
+	}
+	if len(states) == 0 {
+		return nil
+	}
+	// Prefer the least loaded pods when loading adapters.
+	sort.SliceStable(states, func(i, j int) bool {
+		if states[i].pm.WaitingQueueSize != states[j].pm.WaitingQueueSize {
+			return states[i].pm.WaitingQueueSize < states[j].pm.WaitingQueueSize
+		}
+		return states[i].pm.Name < states[j].pm.Name
+	})
+
+	wanted := make(map[string]bool, len(registered))
+	var totalDemand float64
+	for name := range registered {
+		wanted[name] = true
+	}
+	for name, d := range demand {
+		wanted[name] = true
+		totalDemand += d
+	}
+	desired := make(map[string]int, len(wanted))
+	var ordered []string
+	for name := range wanted {
+		r := 1
+		if totalDemand > 0 {
+			r = int(math.Ceil(demand[name] / totalDemand * float64(len(states))))
+		}
+		desired[name] = max(1, min(r, len(states)))
+		ordered = append(ordered, name)
+	}
+	// Place the adapters with the most demand first.
+	sort.Slice(ordered, func(i, j int) bool {
+		if demand[ordered[i]] != demand[ordered[j]] {
+			return demand[ordered[i]] > demand[ordered[j]]
+		}
+		return ordered[i] < ordered[j]
+	})
+
+	var actions []action
+	// budgetLeft returns whether n more actions can be planned.
+	budgetLeft := func(n int) bool {
+		return maxActions <= 0 || len(actions)+n <= maxActions
+	}
+	unload := func(s *podState, name string) {
+		actions = append(actions, action{pod: s.pm.Pod, adapter: name})
+		delete(s.adapters, name)
+		replicas[name]--
+	}
+	// evictable returns the adapter on the pod with the least demand that is either unwanted or
+	// loaded on more pods than desired.
+	evictable := func(s *podState) (string, bool) {
+		victim, found := "", false
+		for name := range s.adapters {
+			if wanted[name] && replicas[name] <= desired[name] {
+				continue
+			}
+			if !found || demand[name] < demand[victim] || (demand[name] == demand[victim] && name < victim) {
+				victim, found = name, true
+			}
+		}
+		return victim, found
+	}
+
+	for _, name := range ordered {
+		for replicas[name] < desired[name] && budgetLeft(1) {
+			var target *podState
+			for _, s := range states {
+				if !s.adapters[name] && s.hasFreeSlot() {
+					target = s
+					break
+				}
+			}
+			// Evicting an adapter is only worth it if the adapter can be loaded in its place.
+			if target == nil && budgetLeft(2) {
+				for _, s := range states {
+					if s.adapters[name] {
+						continue
+					}
+					if victim, ok := evictable(s); ok {
+						unload(s, victim)
+						target = s
+						break
+					}
+				}
+			}
+			if target == nil {
+				break
+			}
+			actions = append(actions, action{load: true, pod: target.pm.Pod, adapter: name})
+			target.adapters[name] = true
+			replicas[name]++
+		}
+	}
+
+	// Unload the adapters that are no longer wanted, unless they were loaded by someone else.
+	for _, s := range states {
+		var unwanted []string
+		for name := range s.adapters {
+			if !wanted[name] && placed[s.pm.Pod][name] {
+				unwanted = append(unwanted, name)
+			}
+		}
+		sort.Strings(unwanted)
+		for _, name := range unwanted {
+			if !budgetLeft(1) {
+				return actions
+			}
+			unload(s, name)
+		}
+	}
+	return actions
+}
@@ -0,0 +1,201 @@
+package lora
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+)
+
+func fakePod(name string, queue, maxAdapters int, adapters ...string) *backend.PodMetrics {
+	pm := &backend.PodMetrics{
+		Pod: backend.Pod{Name: name, Address: name},
+		Metrics: backend.Metrics{
+			WaitingQueueSize: queue,
+			MaxActiveModels:  maxAdapters,
+			ActiveModels:     map[string]int{},
+		},
+	}
+	for _, a := range adapters {
+		pm.ActiveModels[a] = 0
+	}
+	return pm
+}
+
+func load(pod, adapter string) action {
+	return action{load: true, pod: backend.Pod{Name: pod, Address: pod}, adapter: adapter}
+}
+
+func unload(pod, adapter string) action {
+	return action{pod: backend.Pod{Name: pod, Address: pod}, adapter: adapter}
+}
+
+func placedOn(pod string, adapters ...string) map[backend.Pod]map[string]bool {
+	placed := map[string]bool{}
+	for _, a := range adapters {
+		placed[a] = true
+	}
+	return map[backend.Pod]map[string]bool{{Name: pod, Address: pod}: placed}
+}
+
+func TestPlan(t *testing.T) {
+	tests := []struct {
+		name       string
+		pods       []*backend.PodMetrics
+		registered map[string]bool
+		demand     map[string]float64
+		placed     map[backend.Pod]map[string]bool
+		maxActions int
+		want       []action
+	}{
+		{
+			name:       "registered adapter is loaded on the least loaded pod",
+			pods:       []*backend.PodMetrics{fakePod("pod1", 5, 2), fakePod("pod2", 1, 2)},
+			registered: map[string]bool{"a": true},
+			want:       []action{load("pod2", "a")},
+		},
+		{
+			name:       "adapter already placed",
+			pods:       []*backend.PodMetrics{fakePod("pod1", 0, 2, "a"), fakePod("pod2", 0, 2)},
+			registered: map[string]bool{"a": true},
+		},
+		{
+			name:       "pods without adapter slots are ignored",
+			pods:       []*backend.PodMetrics{fakePod("pod1", 0, 0)},
+			registered: map[string]bool{"a": true},
+		},
+		{
+			name:       "adapter with high demand is loaded on more pods",
+			pods:       []*backend.PodMetrics{fakePod("pod1", 0, 2, "a", "b"), fakePod("pod2", 0, 2), fakePod("pod3", 0, 2)},
+			registered: map[string]bool{"a": true, "b": true},
+			demand:     map[string]float64{"a": 90, "b": 10},
+			want:       []action{load("pod2", "a"), load("pod3", "a")},
+		},
+		{
+			name:       "unwanted adapter is evicted to make room",
+			pods:       []*backend.PodMetrics{fakePod("pod1", 0, 2, "a", "old")},
+			registered: map[string]bool{"a": true, "b": true},
+			want:       []action{unload("pod1", "old"), load("pod1", "b")},
+		},
+		{
+			name:       "over-replicated adapter is evicted to make room",
+			pods:       []*backend.PodMetrics{fakePod("pod1", 0, 1, "a"), fakePod("pod2", 0, 1, "a")},
+			registered: map[string]bool{"a": true, "b": true},
+			demand:     map[string]float64{"a": 1, "b": 1},
+			want:       []action{unload("pod1", "a"), load("pod1", "b")},
+		},
+		{
+			name:       "unregistered adapter with demand is kept",
+			pods:       []*backend.PodMetrics{fakePod("pod1", 0, 2, "passthrough")},
+			registered: map[string]bool{},
+			demand:     map[string]float64{"passthrough": 1},
+		},
+		{
+			name:       "unwanted adapters are unloaded",
+			pods:       []*backend.PodMetrics{fakePod("pod1", 0, 2, "old1", "old2")},
+			registered: map[string]bool{},
+			placed:     placedOn("pod1", "old1", "old2"),
+			want:       []action{unload("pod1", "old1"), unload("pod1", "old2")},
+		},
+		{
+			name:       "unwanted adapters not placed by the manager are kept",
+			pods:       []*backend.PodMetrics{fakePod("pod1", 0, 3, "old1", "old2")},
+			registered: map[string]bool{},
+			placed:     placedOn("pod1", "old1"),
+			want:       []action{unload("pod1", "old1")},
+		},
+		{
+			name:       "actions are limited",
+			pods:       []*backend.PodMetrics{fakePod("pod1", 0, 2, "old1", "old2")},
+			registered: map[string]bool{},
+			placed:     placedOn("pod1", "old1", "old2"),
+			maxActions: 1,
+			want:       []action{unload("pod1", "old1")},
+		},
+		{
+			name:       "adapters are not evicted without budget to load another one",
+			pods:       []*backend.PodMetrics{fakePod("pod1", 0, 1, "old")},
+			registered: map[string]bool{"a": true},
+			maxActions: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := plan(test.pods, test.registered, test.demand, test.placed, test.maxActions)
+			if diff := cmp.Diff(test.want, got, cmp.AllowUnexported(action{})); diff != "" {
+				t.Errorf("Unexpected actions (-want +got): %v", diff)
+			}
+		})
+	}
+}
+
+type fakePodMetricsProvider []*backend.PodMetrics
+
+func (f fakePodMetricsProvider) AllPodMetrics() []*backend.PodMetrics {
+	return f
+}
+
+type fakeModelLister []*v1alpha1.InferenceModel
+
+func (f fakeModelLister) AllInferenceModels() []*v1alpha1.InferenceModel {
+	return f
+}
+
+type fakeAdapterClient struct {
+	calls []string
+}
+
+func (f *fakeAdapterClient) LoadAdapter(ctx context.Context, pod backend.Pod, name, source string) error {
+	f.calls = append(f.calls, "load "+name+" from "+source+" on "+pod.Name)
+	return nil
+}
+
+func (f *fakeAdapterClient) UnloadAdapter(ctx context.Context, pod backend.Pod, name string) error {
+	f.calls = append(f.calls, "unload "+name+" from "+pod.Name)
+	return nil
+}
+
+func TestReconcile(t *testing.T) {
+	models := fakeModelLister{
+		{
+			Spec: v1alpha1.InferenceModelSpec{
+				ModelName: "sql",
+				TargetModels: []v1alpha1.TargetModel{
+					{Name: "sql-v1", Weight: 50},
+					{Name: "base", Weight: 50},
+				},
+			},
+		},
+	}
+	pods := fakePodMetricsProvider{fakePod("pod1", 0, 2, "old"), fakePod("pod2", 0, 2)}
+	client := &fakeAdapterClient{}
+	m := NewManager(pods, models, client, Config{SourceDir: "/adapters", BaseModels: []string{"base"}})
+	m.RecordRequest("base")
+	m.RecordRequest("sql-v1")
+
+	if err := m.reconcileOnce(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The old adapter was not loaded by the manager, and is kept.
+	want := []string{
+		"load sql-v1 from /adapters/sql-v1 on pod1",
+		"load sql-v1 from /adapters/sql-v1 on pod2",
+	}
+	if diff := cmp.Diff(want, client.calls); diff != "" {
+		t.Errorf("Unexpected calls (-want +got): %v", diff)
+	}
+	if diff := cmp.Diff(placedOn("pod1", "sql-v1")[pods[0].Pod], m.placed[pods[0].Pod]); diff != "" {
+		t.Errorf("Unexpected adapters placed on pod1 (-want +got): %v", diff)
+	}
+	if got := m.demand["sql-v1"]; got != 1 {
+		t.Errorf("Unexpected demand for sql-v1, got %v, want 1", got)
+	}
+	m.updateDemand()
+	if got := m.demand["sql-v1"]; got != demandDecay {
+		t.Errorf("Unexpected decayed demand for sql-v1, got %v, want %v", got, demandDecay)
+	}
+}
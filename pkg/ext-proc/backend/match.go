@@ -0,0 +1,84 @@
+package backend
+
+import (
+	"strconv"
+	"strings"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+)
+
+// RequestBody gives access to the top-level fields of a request body.
+type RequestBody interface {
+	// Value returns the decoded value of the field, and whether the field is set.
+	Value(name string) (any, bool)
+}
+
+// MatchTargetModel returns the target model of the first rule of the model matched by the request,
+// or an empty string if the request matches no rule. The header names must be lower case.
+func MatchTargetModel(model *v1alpha1.InferenceModel, headers map[string]string, body RequestBody) string {
+	for _, rule := range model.Spec.Rules {
+		if matchesAll(rule.Matches, headers, body) {
+			return rule.TargetModel
+		}
+	}
+	return ""
+}
+
+func matchesAll(matches []v1alpha1.RequestMatch, headers map[string]string, body RequestBody) bool {
+	for _, m := range matches {
+		value, ok := requestValue(m, headers, body)
+		if !ok || !matchValue(m, value) {
+			return false
+		}
+	}
+	// A rule without matches never applies, which the API forbids anyway.
+	return len(matches) > 0
+}
+
+// requestValue returns the value of the header or body field of the match as a string, and
+// whether it is set.
+func requestValue(m v1alpha1.RequestMatch, headers map[string]string, body RequestBody) (string, bool) {
+	switch m.Type {
+	case v1alpha1.HeaderMatch:
+		v, ok := headers[strings.ToLower(m.Name)]
+		return v, ok
+	case v1alpha1.BodyFieldMatch:
+		v, _ := body.Value(m.Name)
+		switch v := v.(type) {
+		case string:
+			return v, true
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case bool:
+			return strconv.FormatBool(v), true
+		default:
+			// Missing and null fields are not set, and objects and arrays can't be compared.
+			return "", false
+		}
+	default:
+		return "", false
+	}
+}
+
+func matchValue(m v1alpha1.RequestMatch, value string) bool {
+	switch m.Operator {
+	case v1alpha1.MatchPresent:
+		return true
+	case v1alpha1.MatchGreaterThan, v1alpha1.MatchLessThan:
+		got, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false
+		}
+		want, err := strconv.ParseFloat(m.Value, 64)
+		if err != nil {
+			return false
+		}
+		if m.Operator == v1alpha1.MatchGreaterThan {
+			return got > want
+		}
+		return got < want
+	default:
+		// Exact is the default operator.
+		return value == m.Value
+	}
+}
@@ -0,0 +1,98 @@
+package backend
+
+import (
+	"testing"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+)
+
+func TestMatchTargetModel(t *testing.T) {
+	model := &v1alpha1.InferenceModel{
+		Spec: v1alpha1.InferenceModelSpec{
+			Rules: []v1alpha1.TargetModelRule{
+				{
+					Matches: []v1alpha1.RequestMatch{
+						{Type: v1alpha1.HeaderMatch, Name: "X-Beta-User", Value: "true"},
+					},
+					TargetModel: "beta",
+				},
+				{
+					Matches: []v1alpha1.RequestMatch{
+						{Type: v1alpha1.BodyFieldMatch, Name: "max_tokens", Operator: v1alpha1.MatchGreaterThan, Value: "4096"},
+						{Type: v1alpha1.BodyFieldMatch, Name: "stream", Operator: v1alpha1.MatchExact, Value: "false"},
+					},
+					TargetModel: "long-context",
+				},
+				{
+					Matches: []v1alpha1.RequestMatch{
+						{Type: v1alpha1.HeaderMatch, Name: "x-debug", Operator: v1alpha1.MatchPresent},
+					},
+					TargetModel: "debug",
+				},
+			},
+		},
+	}
+	tests := []struct {
+		name    string
+		headers map[string]string
+		body    map[string]any
+		want    string
+	}{
+		{
+			name: "no match",
+			body: map[string]any{"max_tokens": float64(100), "stream": false},
+		},
+		{
+			name:    "header match",
+			headers: map[string]string{"x-beta-user": "true"},
+			want:    "beta",
+		},
+		{
+			name:    "header mismatch",
+			headers: map[string]string{"x-beta-user": "false"},
+		},
+		{
+			name:    "first matching rule applies",
+			headers: map[string]string{"x-beta-user": "true"},
+			body:    map[string]any{"max_tokens": float64(8192), "stream": false},
+			want:    "beta",
+		},
+		{
+			name: "all body fields match",
+			body: map[string]any{"max_tokens": float64(8192), "stream": false},
+			want: "long-context",
+		},
+		{
+			name: "not all body fields match",
+			body: map[string]any{"max_tokens": float64(8192), "stream": true},
+		},
+		{
+			name: "number compared to a missing field",
+			body: map[string]any{"stream": false},
+		},
+		{
+			name: "number compared to a non numeric field",
+			body: map[string]any{"max_tokens": "many", "stream": false},
+		},
+		{
+			name:    "header present",
+			headers: map[string]string{"x-debug": ""},
+			want:    "debug",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := MatchTargetModel(model, test.headers, mapBody(test.body)); got != test.want {
+				t.Errorf("MatchTargetModel() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// mapBody is a RequestBody of decoded fields.
+type mapBody map[string]any
+
+func (b mapBody) Value(name string) (any, bool) {
+	v, ok := b[name]
+	return v, ok
+}
@@ -0,0 +1,166 @@
+package backend
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	orcav3 "github.com/cncf/xds/go/xds/data/orca/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// The headers or trailers of the responses carrying ORCA load reports. The value of ORCAHeader is
+// prefixed by its format, "TEXT", "JSON" or "BIN", e.g.
+// "TEXT named_metrics.waiting_queue_size=2, utilization.kv_cache=0.4". The value of
+// ORCABinaryHeader is a base64 encoded load report.
+const (
+	ORCAHeader       = "endpoint-load-metrics"
+	ORCABinaryHeader = "endpoint-load-metrics-bin"
+)
+
+// The metrics of ORCA load reports mapped to the metrics of a pod. The KV cache usage is a
+// utilization, between 0 and 1, and the others are named metrics.
+const (
+	ORCAKVCacheUtilization = "kv_cache"
+	ORCAWaitingQueueSize   = "waiting_queue_size"
+	ORCARunningQueueSize   = "running_queue_size"
+	ORCAMaxActiveModels    = "max_active_models"
+	// ORCAActiveModelPrefix prefixes the named metrics of the active models, such as
+	// "active_model.sql-lora", and their value is the number of copies of the model loaded.
+	ORCAActiveModelPrefix = "active_model."
+)
+
+// ApplyORCALoadReport returns the metrics of the pod updated with the metrics set in the ORCA load
+// report. The metrics missing from the report are kept. The active models are replaced if the
+// report sets the maximum number of active models, which means it reports the models as a whole.
+func ApplyORCALoadReport(existing *PodMetrics, report *orcav3.OrcaLoadReport) *PodMetrics {
+	updated := existing.Clone()
+	if v, ok := report.GetUtilization()[ORCAKVCacheUtilization]; ok {
+		updated.KVCacheUsagePercent = v
+	}
+	named := report.GetNamedMetrics()
+	if v, ok := named[ORCAWaitingQueueSize]; ok {
+		updated.WaitingQueueSize = int(v)
+	}
+	if v, ok := named[ORCARunningQueueSize]; ok {
+		updated.RunningQueueSize = int(v)
+	}
+	if v, ok := named[ORCAMaxActiveModels]; ok {
+		updated.MaxActiveModels = int(v)
+		updated.ActiveModels = make(map[string]int)
+		for name, count := range named {
+			if model, ok := strings.CutPrefix(name, ORCAActiveModelPrefix); ok && count > 0 {
+				updated.ActiveModels[model] = int(count)
+			}
+		}
+	}
+	return updated
+}
+
+// IsCompleteORCALoadReport returns whether the ORCA load report sets all the metrics scraped from
+// the pods, except the KV cache capacity, which does not change.
+func IsCompleteORCALoadReport(report *orcav3.OrcaLoadReport) bool {
+	if _, ok := report.GetUtilization()[ORCAKVCacheUtilization]; !ok {
+		return false
+	}
+	named := report.GetNamedMetrics()
+	for _, name := range []string{ORCAWaitingQueueSize, ORCARunningQueueSize, ORCAMaxActiveModels} {
+		if _, ok := named[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseORCAHeader parses the load report in the value of the ORCAHeader, or ORCABinaryHeader if
+// binary is set.
+func ParseORCAHeader(value string, binary bool) (*orcav3.OrcaLoadReport, error) {
+	if binary {
+		return parseORCABinary(value)
+	}
+	format, data, _ := strings.Cut(strings.TrimSpace(value), " ")
+	switch format {
+	case "TEXT":
+		return parseORCAText(data)
+	case "JSON":
+		report := &orcav3.OrcaLoadReport{}
+		if err := protojson.Unmarshal([]byte(data), report); err != nil {
+			return nil, fmt.Errorf("invalid JSON load report: %w", err)
+		}
+		return report, nil
+	case "BIN":
+		return parseORCABinary(data)
+	default:
+		return nil, fmt.Errorf("unsupported load report format %q", format)
+	}
+}
+
+func parseORCABinary(data string) (*orcav3.OrcaLoadReport, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 load report: %w", err)
+	}
+	report := &orcav3.OrcaLoadReport{}
+	if err := proto.Unmarshal(raw, report); err != nil {
+		return nil, fmt.Errorf("invalid binary load report: %w", err)
+	}
+	return report, nil
+}
+
+// parseORCAText parses the comma separated metrics of a load report in the text format.
+func parseORCAText(data string) (*orcav3.OrcaLoadReport, error) {
+	report := &orcav3.OrcaLoadReport{}
+	for _, metric := range strings.Split(data, ",") {
+		metric = strings.TrimSpace(metric)
+		if metric == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(metric, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid load report metric %q", metric)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of load report metric %q: %w", name, err)
+		}
+		name = strings.TrimSpace(name)
+		if key, ok := strings.CutPrefix(name, "named_metrics."); ok {
+			if report.NamedMetrics == nil {
+				report.NamedMetrics = make(map[string]float64)
+			}
+			report.NamedMetrics[key] = v
+			continue
+		}
+		if key, ok := strings.CutPrefix(name, "utilization."); ok {
+			if report.Utilization == nil {
+				report.Utilization = make(map[string]float64)
+			}
+			report.Utilization[key] = v
+			continue
+		}
+		if key, ok := strings.CutPrefix(name, "request_cost."); ok {
+			if report.RequestCost == nil {
+				report.RequestCost = make(map[string]float64)
+			}
+			report.RequestCost[key] = v
+			continue
+		}
+		switch name {
+		case "cpu_utilization":
+			report.CpuUtilization = v
+		case "mem_utilization":
+			report.MemUtilization = v
+		case "application_utilization":
+			report.ApplicationUtilization = v
+		case "rps_fractional":
+			report.RpsFractional = v
+		case "eps":
+			report.Eps = v
+		default:
+			return nil, fmt.Errorf("unsupported load report metric %q", name)
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,143 @@
+package backend
+
+import (
+	"testing"
+
+	orcav3 "github.com/cncf/xds/go/xds/data/orca/v3"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func TestApplyORCALoadReport(t *testing.T) {
+	tests := []struct {
+		name   string
+		report *orcav3.OrcaLoadReport
+		want   Metrics
+	}{
+		{
+			name:   "Empty report keeps the metrics",
+			report: &orcav3.OrcaLoadReport{},
+			want:   pod1.Metrics,
+		},
+		{
+			name: "Queue and KV cache",
+			report: &orcav3.OrcaLoadReport{
+				Utilization:  map[string]float64{ORCAKVCacheUtilization: 0.7},
+				NamedMetrics: map[string]float64{ORCAWaitingQueueSize: 3, ORCARunningQueueSize: 5},
+			},
+			want: Metrics{
+				WaitingQueueSize:    3,
+				RunningQueueSize:    5,
+				KVCacheUsagePercent: 0.7,
+				MaxActiveModels:     2,
+				ActiveModels:        map[string]int{"foo": 1, "bar": 1},
+			},
+		},
+		{
+			name: "Active models replaced",
+			report: &orcav3.OrcaLoadReport{
+				NamedMetrics: map[string]float64{
+					ORCAMaxActiveModels:              4,
+					ORCAActiveModelPrefix + "baz":    1,
+					ORCAActiveModelPrefix + "unload": 0,
+				},
+			},
+			want: Metrics{
+				KVCacheUsagePercent: 0.2,
+				MaxActiveModels:     4,
+				ActiveModels:        map[string]int{"baz": 1},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ApplyORCALoadReport(pod1, test.report)
+			if diff := cmp.Diff(test.want, got.Metrics); diff != "" {
+				t.Errorf("Unexpected output (-want +got): %v", diff)
+			}
+			if got == pod1 {
+				t.Errorf("The existing metrics were modified")
+			}
+		})
+	}
+}
+
+func TestIsCompleteORCALoadReport(t *testing.T) {
+	complete := &orcav3.OrcaLoadReport{
+		Utilization:  map[string]float64{ORCAKVCacheUtilization: 0.5},
+		NamedMetrics: map[string]float64{ORCAWaitingQueueSize: 2, ORCARunningQueueSize: 1, ORCAMaxActiveModels: 4},
+	}
+	if !IsCompleteORCALoadReport(complete) {
+		t.Errorf("IsCompleteORCALoadReport(%v) = false, want true", complete)
+	}
+	partial := &orcav3.OrcaLoadReport{
+		Utilization:  map[string]float64{ORCAKVCacheUtilization: 0.5},
+		NamedMetrics: map[string]float64{ORCAWaitingQueueSize: 2, ORCARunningQueueSize: 1},
+	}
+	if IsCompleteORCALoadReport(partial) {
+		t.Errorf("IsCompleteORCALoadReport(%v) = true, want false", partial)
+	}
+}
+
+func TestParseORCAHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		binary  bool
+		want    *orcav3.OrcaLoadReport
+		wantErr bool
+	}{
+		{
+			name:  "Text",
+			value: "TEXT cpu_utilization=0.3, named_metrics.waiting_queue_size=2, utilization.kv_cache=0.4, request_cost.tokens=10",
+			want: &orcav3.OrcaLoadReport{
+				CpuUtilization: 0.3,
+				NamedMetrics:   map[string]float64{ORCAWaitingQueueSize: 2},
+				Utilization:    map[string]float64{ORCAKVCacheUtilization: 0.4},
+				RequestCost:    map[string]float64{"tokens": 10},
+			},
+		},
+		{
+			name:  "JSON",
+			value: `JSON {"named_metrics": {"running_queue_size": 4}}`,
+			want:  &orcav3.OrcaLoadReport{NamedMetrics: map[string]float64{ORCARunningQueueSize: 4}},
+		},
+		{
+			name:  "Binary format",
+			value: "BIN KhMKCGt2X2NhY2hlEQAAAAAAAOA/",
+			want:  &orcav3.OrcaLoadReport{Utilization: map[string]float64{ORCAKVCacheUtilization: 0.5}},
+		},
+		{
+			name:   "Binary header",
+			value:  "KhMKCGt2X2NhY2hlEQAAAAAAAOA/",
+			binary: true,
+			want:   &orcav3.OrcaLoadReport{Utilization: map[string]float64{ORCAKVCacheUtilization: 0.5}},
+		},
+		{
+			name:    "Unsupported format",
+			value:   "named_metrics.waiting_queue_size=2",
+			wantErr: true,
+		},
+		{
+			name:    "Unsupported metric",
+			value:   "TEXT queue=2",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid value",
+			value:   "TEXT named_metrics.waiting_queue_size=two",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseORCAHeader(test.value, test.binary)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Unexpected error, got %v, want error %v", err, test.wantErr)
+			}
+			if diff := cmp.Diff(test.want, got, protocmp.Transform()); diff != "" {
+				t.Errorf("Unexpected output (-want +got): %v", diff)
+			}
+		})
+	}
+}
@@ -3,50 +3,172 @@ package backend
 import (
 	"context"
 	"fmt"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	orcav3 "github.com/cncf/xds/go/xds/data/orca/v3"
 	"go.uber.org/multierr"
 	klog "k8s.io/klog/v2"
 )
 
 const (
-	fetchMetricsTimeout = 5 * time.Second
+	fetchMetricsTimeout    = 5 * time.Second
+	minFetchMetricsTimeout = 100 * time.Millisecond
+	// scrapeJitter is the fraction of the scrape interval of a pod its scrapes are jittered by.
+	scrapeJitter = 0.2
+	// scrapeTicksPerInterval is how many times per minimum scrape interval the due scrapes are
+	// started, so that the jittered scrapes of the pods spread within the interval.
+	scrapeTicksPerInterval = 4
 )
 
-func NewProvider(pmc PodMetricsClient, datastore *K8sDatastore) *Provider {
+func NewProvider(pmc PodMetricsClient, datastore *K8sDatastore, options ...ProviderOption) *Provider {
 	p := &Provider{
 		podMetrics: sync.Map{},
 		pmc:        pmc,
 		datastore:  datastore,
 	}
+	for _, opt := range options {
+		opt(p)
+	}
+	p.snapshot.Store(&Snapshot{})
 	return p
 }
 
+type ProviderOption func(*Provider)
+
+// WithPushedMetricsTTL stops polling the metrics of the pods which reported their load, by pushing
+// a load report or in a response, within the ttl. Only the load reports with all the polled metrics
+// count, see IsCompleteORCALoadReport, and pods are polled at least once, for their KV cache
+// capacity. Pods are polled again once their last complete load report is older than the ttl, e.g.
+// if they stop pushing.
+func WithPushedMetricsTTL(ttl time.Duration) ProviderOption {
+	return func(p *Provider) {
+		p.pushedMetricsTTL = ttl
+	}
+}
+
+// WithMaxConcurrentScrapes limits the number of pods whose metrics are scraped at the same time. A
+// non-positive n does not limit them.
+func WithMaxConcurrentScrapes(n int) ProviderOption {
+	return func(p *Provider) {
+		p.maxConcurrentScrapes = n
+	}
+}
+
+// WithMaxScrapeInterval lets the interval between the scrapes of the metrics of an idle pod grow
+// from the refresh metrics interval up to max. Busy pods, with requests running or waiting, are
+// scraped at the refresh metrics interval.
+func WithMaxScrapeInterval(max time.Duration) ProviderOption {
+	return func(p *Provider) {
+		p.maxScrapeInterval = max
+	}
+}
+
 // Provider provides backend pods and information such as metrics.
 type Provider struct {
 	// key: Pod, value: *PodMetrics
 	podMetrics sync.Map
 	pmc        PodMetricsClient
 	datastore  *K8sDatastore
+	// snapshot is the last published view of podMetrics, which is read without locking.
+	snapshot atomic.Pointer[Snapshot]
+	// lastReport is when each pod last reported all its metrics. key: Pod, value: time.Time
+	lastReport       sync.Map
+	pushedMetricsTTL time.Duration
+	// scrapes is when the metrics of each pod are next scraped. key: Pod, value: *scrapeState
+	scrapes              sync.Map
+	minScrapeInterval    time.Duration
+	maxScrapeInterval    time.Duration
+	maxConcurrentScrapes int
+	// history is the recent metrics of each pod. key: Pod, value: *metricsHistory
+	history sync.Map
+	// publishMu serializes the publication of snapshots, so that their versions are in order.
+	publishMu sync.Mutex
+	// dirty is set when podMetrics changed since the last published snapshot.
+	dirty atomic.Bool
+}
+
+// Snapshot is an immutable view of the pods and their metrics at a point in time. Neither the
+// slice nor the PodMetrics may be modified.
+type Snapshot struct {
+	// Version increases with every snapshot published by the provider.
+	Version uint64
+	Pods    []*PodMetrics
 }
 
 type PodMetricsClient interface {
 	FetchMetrics(ctx context.Context, pod Pod, existing *PodMetrics) (*PodMetrics, error)
 }
 
+// AllPodMetrics returns the pods and their metrics of the last snapshot. The result must not be
+// modified.
 func (p *Provider) AllPodMetrics() []*PodMetrics {
-	res := []*PodMetrics{}
-	fn := func(k, v any) bool {
-		res = append(res, v.(*PodMetrics))
+	return p.Snapshot().Pods
+}
+
+// Snapshot returns the last published snapshot of the pods and their metrics. It does not lock nor
+// allocate, and the snapshot stays consistent while metrics are refreshed.
+func (p *Provider) Snapshot() *Snapshot {
+	return p.snapshot.Load()
+}
+
+// publish publishes a new snapshot of the pods and their metrics.
+func (p *Provider) publish() {
+	p.publishMu.Lock()
+	defer p.publishMu.Unlock()
+	p.dirty.Store(false)
+	pods := []*PodMetrics{}
+	p.podMetrics.Range(func(k, v any) bool {
+		pods = append(pods, v.(*PodMetrics))
 		return true
-	}
-	p.podMetrics.Range(fn)
-	return res
+	})
+	p.snapshot.Store(&Snapshot{Version: p.snapshot.Load().Version + 1, Pods: pods})
 }
 
+// UpdatePodMetrics updates the metrics of the pod. Like load reports, the metrics are published with
+// the next refresh of the metrics, so that frequent updates do not publish a snapshot each.
 func (p *Provider) UpdatePodMetrics(pod Pod, pm *PodMetrics) {
 	p.podMetrics.Store(pod, pm)
+	p.dirty.Store(true)
+}
+
+// FindPod returns the pod with the name, if it exists.
+func (p *Provider) FindPod(name string) (Pod, bool) {
+	for _, pm := range p.Snapshot().Pods {
+		if pm.Name == name {
+			return pm.Pod, true
+		}
+	}
+	return Pod{}, false
+}
+
+// ApplyLoadReport updates the metrics of the pod from a load report, pushed by the pod or in a
+// response of the pod. The metrics are published with the next refresh of the metrics, so that
+// frequent reports do not publish a snapshot each.
+func (p *Provider) ApplyLoadReport(pod Pod, report *orcav3.OrcaLoadReport) {
+	existing, ok := p.GetPodMetrics(pod)
+	if !ok {
+		// The pod was removed.
+		return
+	}
+	p.podMetrics.Store(pod, p.recordHistory(pod, ApplyORCALoadReport(existing, report)))
+	// The pod is still polled for the metrics missing from partial reports, such as the active
+	// models.
+	if IsCompleteORCALoadReport(report) {
+		p.lastReport.Store(pod, time.Now())
+	}
+	p.dirty.Store(true)
+}
+
+// reportedRecently returns whether the pod reported all its metrics within the pushed metrics TTL.
+func (p *Provider) reportedRecently(pod Pod, now time.Time) bool {
+	if p.pushedMetricsTTL <= 0 {
+		return false
+	}
+	last, ok := p.lastReport.Load(pod)
+	return ok && now.Sub(last.(time.Time)) < p.pushedMetricsTTL
 }
 
 func (p *Provider) GetPodMetrics(pod Pod) (*PodMetrics, bool) {
@@ -58,6 +180,8 @@ func (p *Provider) GetPodMetrics(pod Pod) (*PodMetrics, bool) {
 }
 
 func (p *Provider) Init(refreshPodsInterval, refreshMetricsInterval time.Duration) error {
+	p.minScrapeInterval = refreshMetricsInterval
+	p.maxScrapeInterval = max(p.maxScrapeInterval, refreshMetricsInterval)
 	if err := p.refreshPodsOnce(); err != nil {
 		klog.Errorf("Failed to init pods: %v", err)
 	}
@@ -77,10 +201,10 @@ func (p *Provider) Init(refreshPodsInterval, refreshMetricsInterval time.Duratio
 		}
 	}()
 
-	// periodically refresh metrics
+	// periodically start the due scrapes of the metrics
 	go func() {
 		for {
-			time.Sleep(refreshMetricsInterval)
+			time.Sleep(refreshMetricsInterval / scrapeTicksPerInterval)
 			if err := p.refreshMetricsOnce(); err != nil {
 				klog.V(4).Infof("Failed to refresh metrics: %v", err)
 			}
@@ -123,57 +247,149 @@ func (p *Provider) refreshPodsOnce() error {
 		pod := k.(Pod)
 		if _, ok := p.datastore.pods.Load(pod); !ok {
 			p.podMetrics.Delete(pod)
+			p.lastReport.Delete(pod)
+			p.scrapes.Delete(pod)
+			p.history.Delete(pod)
 		}
 		return true
 	}
 	p.podMetrics.Range(mergeFn)
 	p.datastore.pods.Range(addNewPods)
+	p.publish()
 	return nil
 }
 
+// refreshMetricsOnce fetches the metrics of the pods whose scrape is due, at most
+// maxConcurrentScrapes at a time, and publishes them at once.
 func (p *Provider) refreshMetricsOnce() error {
-	ctx, cancel := context.WithTimeout(context.Background(), fetchMetricsTimeout)
-	defer cancel()
 	start := time.Now()
 	defer func() {
 		d := time.Since(start)
 		// TODO: add a metric instead of logging
 		klog.V(4).Infof("Refreshed metrics in %v", d)
 	}()
-	var wg sync.WaitGroup
-	errCh := make(chan error)
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		errs    error
+		sem     chan struct{}
+		scraped bool
+	)
+	if p.maxConcurrentScrapes > 0 {
+		sem = make(chan struct{}, p.maxConcurrentScrapes)
+	}
 	processOnePod := func(key, value any) bool {
 		klog.V(4).Infof("Processing pod %v and metric %v", key, value)
 		pod := key.(Pod)
 		existing := value.(*PodMetrics)
+		var state *scrapeState
+		if v, ok := p.scrapes.Load(pod); ok {
+			state = v.(*scrapeState)
+			if p.reportedRecently(pod, start) {
+				// The pod reports its load, which is fresher than polled metrics.
+				return true
+			}
+			if start.Before(state.next) {
+				return true
+			}
+		}
+		if sem != nil {
+			sem <- struct{}{}
+		}
+		scraped = true
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			interval := p.minScrapeInterval
+			if state != nil {
+				interval = state.interval
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout(interval))
+			defer cancel()
 			updated, err := p.pmc.FetchMetrics(ctx, pod, existing)
+			p.scheduleScrape(pod, interval, err == nil && updated.busy())
 			if err != nil {
-				errCh <- fmt.Errorf("failed to parse metrics from %s: %v", pod, err)
+				mu.Lock()
+				errs = multierr.Append(errs, fmt.Errorf("failed to parse metrics from %s: %v", pod, err))
+				mu.Unlock()
+				return
+			}
+			updated = p.recordHistory(pod, updated)
+			// The new metrics of all pods are published at once when they are all fetched. The
+			// metrics are dropped if the pod reported its load while they were fetched, since the
+			// load report is fresher.
+			if !p.podMetrics.CompareAndSwap(pod, existing, updated) {
 				return
 			}
-			p.UpdatePodMetrics(pod, updated)
 			klog.V(4).Infof("Updated metrics for pod %s: %v", pod, updated.Metrics)
 		}()
 		return true
 	}
 	p.podMetrics.Range(processOnePod)
+	wg.Wait()
+	if scraped || p.dirty.Load() {
+		p.publish()
+	}
+	return errs
+}
 
-	// Wait for metric collection for all pods to complete and close the error channel in a
-	// goroutine so this is unblocking, allowing the code to proceed to the error collection code
-	// below.
-	// Note we couldn't use a buffered error channel with a size because the size of the podMetrics
-	// sync.Map is unknown beforehand.
-	go func() {
-		wg.Wait()
-		close(errCh)
-	}()
+// recordHistory adds the new metrics of the pod to its history, and returns a copy of the metrics
+// with their trend.
+func (p *Provider) recordHistory(pod Pod, updated *PodMetrics) *PodMetrics {
+	h, _ := p.history.LoadOrStore(pod, &metricsHistory{})
+	withTrend := *updated
+	withTrend.Trend = h.(*metricsHistory).add(time.Now(), &updated.Metrics)
+	return &withTrend
+}
+
+// busy returns whether the pod has requests running or waiting.
+func (m *Metrics) busy() bool {
+	return m.RunningQueueSize > 0 || m.WaitingQueueSize > 0
+}
+
+// scrapeState is when the metrics of a pod are next scraped.
+type scrapeState struct {
+	interval time.Duration
+	next     time.Time
+}
+
+// scheduleScrape schedules the next scrape of the pod after a scrape at the interval. Busy pods
+// are scraped at the minimum interval, and the interval of idle pods, or pods whose scrape failed,
+// doubles up to the maximum interval. The interval is jittered, so that the scrapes of the pods
+// spread over time.
+func (p *Provider) scheduleScrape(pod Pod, interval time.Duration, busy bool) {
+	interval = nextScrapeInterval(interval, p.minScrapeInterval, p.maxScrapeInterval, busy)
+	p.scrapes.Store(pod, &scrapeState{
+		interval: interval,
+		next:     time.Now().Add(jitter(interval, rand.Float64())),
+	})
+}
 
-	var errs error
-	for err := range errCh {
-		errs = multierr.Append(errs, err)
+func nextScrapeInterval(interval, minInterval, maxInterval time.Duration, busy bool) time.Duration {
+	if busy {
+		return minInterval
 	}
-	return errs
+	interval *= 2
+	if interval > maxInterval {
+		interval = maxInterval
+	}
+	if interval < minInterval {
+		interval = minInterval
+	}
+	return interval
+}
+
+// jitter shortens the interval by up to scrapeJitter of it, r being a random number in [0, 1).
+// Shortening rather than lengthening keeps the pods scraped at least as often as their interval.
+func jitter(interval time.Duration, r float64) time.Duration {
+	return interval - time.Duration(float64(interval)*scrapeJitter*r)
+}
+
+// scrapeTimeout returns the timeout of a scrape at the interval, so that a slow pod does not delay
+// the scrapes of the other pods much beyond their interval.
+func scrapeTimeout(interval time.Duration) time.Duration {
+	return min(max(interval, minFetchMetricsTimeout), fetchMetricsTimeout)
 }
@@ -1,11 +1,14 @@
 package backend
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"testing"
 	"time"
 
+	orcav3 "github.com/cncf/xds/go/xds/data/orca/v3"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 )
@@ -98,13 +101,62 @@ func TestProvider(t *testing.T) {
 			lessFunc := func(a, b *PodMetrics) bool {
 				return a.String() < b.String()
 			}
-			if diff := cmp.Diff(test.want, metrics, cmpopts.SortSlices(lessFunc)); diff != "" {
+			if diff := cmp.Diff(test.want, metrics, cmpopts.SortSlices(lessFunc), cmpopts.IgnoreFields(Metrics{}, "Trend")); diff != "" {
 				t.Errorf("Unexpected output (-want +got): %v", diff)
 			}
 		})
 	}
 }
 
+func TestProviderSnapshot(t *testing.T) {
+	p := NewProvider(&FakePodMetricsClient{}, &K8sDatastore{pods: populateMap(pod1.Pod)})
+	if got := p.Snapshot(); got.Version != 0 || len(got.Pods) != 0 {
+		t.Fatalf("Unexpected initial snapshot %+v", got)
+	}
+	if err := p.refreshPodsOnce(); err != nil {
+		t.Fatalf("refreshPodsOnce: %v", err)
+	}
+	before := p.Snapshot()
+	if before.Version != 1 || len(before.Pods) != 1 {
+		t.Fatalf("Unexpected snapshot after refreshing pods %+v", before)
+	}
+
+	p.UpdatePodMetrics(pod1.Pod, pod1)
+	if got := p.Snapshot(); got != before {
+		t.Errorf("Snapshot published before the next refresh: %+v", got)
+	}
+	p.publish()
+	after := p.Snapshot()
+	if after.Version != 2 {
+		t.Errorf("Unexpected snapshot version, got %v, want 2", after.Version)
+	}
+	if diff := cmp.Diff([]*PodMetrics{pod1}, after.Pods); diff != "" {
+		t.Errorf("Unexpected pods in the new snapshot (-want +got): %v", diff)
+	}
+	// Published snapshots are never modified.
+	if before.Pods[0] == pod1 {
+		t.Errorf("Previous snapshot was modified: %+v", before.Pods[0])
+	}
+}
+
+func BenchmarkAllPodMetrics(b *testing.B) {
+	pods := &sync.Map{}
+	for i := 0; i < 200; i++ {
+		pods.Store(Pod{Name: fmt.Sprintf("pod-%d", i)}, true)
+	}
+	p := NewProvider(&FakePodMetricsClient{}, &K8sDatastore{pods: pods})
+	if err := p.refreshPodsOnce(); err != nil {
+		b.Fatalf("refreshPodsOnce: %v", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if len(p.AllPodMetrics()) != 200 {
+			b.Fatal("Unexpected number of pods")
+		}
+	}
+}
+
 func populateMap(pods ...Pod) *sync.Map {
 	newMap := &sync.Map{}
 	for _, pod := range pods {
@@ -112,3 +164,165 @@ func populateMap(pods ...Pod) *sync.Map {
 	}
 	return newMap
 }
+
+func TestProviderPushedLoadReport(t *testing.T) {
+	polled := &PodMetrics{Pod: pod1.Pod, Metrics: Metrics{WaitingQueueSize: 10}}
+	p := NewProvider(&FakePodMetricsClient{Res: map[Pod]*PodMetrics{pod1.Pod: polled}},
+		&K8sDatastore{pods: populateMap(pod1.Pod)}, WithPushedMetricsTTL(time.Minute))
+	if err := p.refreshPodsOnce(); err != nil {
+		t.Fatalf("refreshPodsOnce: %v", err)
+	}
+	if _, ok := p.FindPod("unknown"); ok {
+		t.Errorf("Unexpected pod found")
+	}
+	pod, ok := p.FindPod(pod1.Name)
+	if !ok {
+		t.Fatalf("Pod %q not found", pod1.Name)
+	}
+
+	// The pod is polled once for its KV cache capacity.
+	if err := p.refreshMetricsOnce(); err != nil {
+		t.Fatalf("refreshMetricsOnce: %v", err)
+	}
+
+	// A partial report does not stop polling the metrics it lacks.
+	p.ApplyLoadReport(pod, &orcav3.OrcaLoadReport{NamedMetrics: map[string]float64{ORCAWaitingQueueSize: 2}})
+	if err := p.refreshMetricsOnce(); err != nil {
+		t.Fatalf("refreshMetricsOnce: %v", err)
+	}
+	if got := p.AllPodMetrics()[0].WaitingQueueSize; got != 10 {
+		t.Errorf("Unexpected waiting queue size after a partial report, got %v, want polled 10", got)
+	}
+
+	p.ApplyLoadReport(pod, &orcav3.OrcaLoadReport{
+		Utilization:  map[string]float64{ORCAKVCacheUtilization: 0.5},
+		NamedMetrics: map[string]float64{ORCAWaitingQueueSize: 2, ORCARunningQueueSize: 1, ORCAMaxActiveModels: 4},
+	})
+	// The pushed metrics are published by the next refresh, which does not poll the pod.
+	if err := p.refreshMetricsOnce(); err != nil {
+		t.Fatalf("refreshMetricsOnce: %v", err)
+	}
+	if got := p.AllPodMetrics()[0].WaitingQueueSize; got != 2 {
+		t.Errorf("Unexpected waiting queue size, got %v, want pushed 2", got)
+	}
+
+	// The pod is polled again once it stops pushing.
+	p.lastReport.Store(pod, time.Now().Add(-2*time.Minute))
+	if err := p.refreshMetricsOnce(); err != nil {
+		t.Fatalf("refreshMetricsOnce: %v", err)
+	}
+	if got := p.AllPodMetrics()[0].WaitingQueueSize; got != 10 {
+		t.Errorf("Unexpected waiting queue size, got %v, want polled 10", got)
+	}
+}
+
+func TestProviderApplyLoadReport(t *testing.T) {
+	p := NewProvider(nil, &K8sDatastore{pods: populateMap(pod1.Pod)})
+	// The pod reports its load while its metrics are fetched.
+	p.pmc = fetchFunc(func(pod Pod, existing *PodMetrics) *PodMetrics {
+		p.ApplyLoadReport(pod, &orcav3.OrcaLoadReport{NamedMetrics: map[string]float64{ORCAWaitingQueueSize: 4}})
+		return &PodMetrics{Pod: pod, Metrics: Metrics{WaitingQueueSize: 10}}
+	})
+	if err := p.refreshPodsOnce(); err != nil {
+		t.Fatalf("refreshPodsOnce: %v", err)
+	}
+	before := p.Snapshot()
+	if err := p.refreshMetricsOnce(); err != nil {
+		t.Fatalf("refreshMetricsOnce: %v", err)
+	}
+	after := p.Snapshot()
+	// Published once by the refresh, with the load report.
+	if after.Version != before.Version+1 {
+		t.Errorf("Unexpected snapshot version, got %v, want %v", after.Version, before.Version+1)
+	}
+	if got := after.Pods[0].WaitingQueueSize; got != 4 {
+		t.Errorf("Unexpected waiting queue size, got %v, want 4 from the load report", got)
+	}
+}
+
+type fetchFunc func(pod Pod, existing *PodMetrics) *PodMetrics
+
+func (f fetchFunc) FetchMetrics(_ context.Context, pod Pod, existing *PodMetrics) (*PodMetrics, error) {
+	return f(pod, existing), nil
+}
+
+func TestNextScrapeInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		busy     bool
+		want     time.Duration
+	}{
+		{name: "Busy pod", interval: 400 * time.Millisecond, busy: true, want: 50 * time.Millisecond},
+		{name: "Idle pod backs off", interval: 100 * time.Millisecond, want: 200 * time.Millisecond},
+		{name: "Idle pod at the maximum", interval: time.Second, want: time.Second},
+		{name: "First scrape", interval: 0, want: 50 * time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := nextScrapeInterval(test.interval, 50*time.Millisecond, time.Second, test.busy); got != test.want {
+				t.Errorf("Unexpected interval, got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestScrapeJitterAndTimeout(t *testing.T) {
+	if got := jitter(time.Second, 0); got != time.Second {
+		t.Errorf("Unexpected jittered interval, got %v, want 1s", got)
+	}
+	if got := jitter(time.Second, 0.5); got != 900*time.Millisecond {
+		t.Errorf("Unexpected jittered interval, got %v, want 900ms", got)
+	}
+	for interval, want := range map[time.Duration]time.Duration{
+		10 * time.Millisecond: minFetchMetricsTimeout,
+		time.Second:           time.Second,
+		time.Minute:           fetchMetricsTimeout,
+	} {
+		if got := scrapeTimeout(interval); got != want {
+			t.Errorf("Unexpected timeout for interval %v, got %v, want %v", interval, got, want)
+		}
+	}
+}
+
+func TestProviderScrapes(t *testing.T) {
+	pods := &sync.Map{}
+	for i := 0; i < 20; i++ {
+		pods.Store(Pod{Name: fmt.Sprintf("pod-%d", i)}, true)
+	}
+	var mu sync.Mutex
+	inFlight, maxInFlight, scrapes := 0, 0, 0
+	p := NewProvider(nil, &K8sDatastore{pods: pods}, WithMaxConcurrentScrapes(3), WithMaxScrapeInterval(time.Hour))
+	p.pmc = fetchFunc(func(pod Pod, existing *PodMetrics) *PodMetrics {
+		mu.Lock()
+		inFlight++
+		scrapes++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return existing
+	})
+	p.minScrapeInterval = time.Minute
+	if err := p.refreshPodsOnce(); err != nil {
+		t.Fatalf("refreshPodsOnce: %v", err)
+	}
+	if err := p.refreshMetricsOnce(); err != nil {
+		t.Fatalf("refreshMetricsOnce: %v", err)
+	}
+	if scrapes != 20 {
+		t.Errorf("Unexpected number of scrapes, got %v, want 20", scrapes)
+	}
+	if maxInFlight > 3 {
+		t.Errorf("Unexpected concurrent scrapes, got %v, want at most 3", maxInFlight)
+	}
+	// The idle pods are not due again before their interval.
+	if err := p.refreshMetricsOnce(); err != nil {
+		t.Fatalf("refreshMetricsOnce: %v", err)
+	}
+	if scrapes != 20 {
+		t.Errorf("Unexpected number of scrapes, got %v, want no new scrape", scrapes)
+	}
+}
@@ -0,0 +1,168 @@
+// Package rollout progressively shifts the traffic of InferenceModels to the target model of their
+// rollout, and rolls the rollout back if the target model fails too many requests or is too slow.
+// The progress of the rollouts is stored in the InferenceModel status, which the ext-proc uses to
+// weigh the target models, see backend.EffectiveTargetModels.
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/multierr"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klog "k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+)
+
+const (
+	// defaultMinRequests is used when the minRequests of a rollout is not set, which matches the
+	// API default.
+	defaultMinRequests = 10
+	// reconcileTimeout bounds the time spent updating the InferenceModels in one reconciliation.
+	reconcileTimeout = 30 * time.Second
+)
+
+// ModelLister lists the InferenceModels served by the pool.
+type ModelLister interface {
+	AllInferenceModels() []*v1alpha1.InferenceModel
+}
+
+func NewController(c client.Client, models ModelLister, tracker *Tracker) *Controller {
+	return &Controller{
+		client:  c,
+		models:  models,
+		tracker: tracker,
+		now:     time.Now,
+	}
+}
+
+// Controller advances the rollouts of the InferenceModels based on their schedule and the stats of
+// the rollout target models observed by this ext-proc.
+//
+// NOTE: Each ext-proc replica only observes the requests it processes. The replicas update the
+// InferenceModel status with optimistic concurrency, so a rollout is rolled back as soon as one
+// replica observes the target model exceeding the thresholds.
+type Controller struct {
+	client  client.Client
+	models  ModelLister
+	tracker *Tracker
+	now     func() time.Time
+}
+
+// Init periodically advances the rollouts.
+func (c *Controller) Init(reconcileInterval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(reconcileInterval)
+			if err := c.reconcileOnce(); err != nil {
+				klog.Errorf("Failed to reconcile rollouts: %v", err)
+			}
+		}
+	}()
+}
+
+func (c *Controller) reconcileOnce() error {
+	ctx, cancel := context.WithTimeout(context.Background(), reconcileTimeout)
+	defer cancel()
+	var errs error
+	for _, model := range c.models.AllInferenceModels() {
+		current := model.Status.Rollout
+		if model.Spec.Rollout == nil && current == nil {
+			continue
+		}
+		now := c.now()
+		var stats Stats
+		if model.Spec.Rollout != nil {
+			// Only the requests sent since the rollout started count. The start of the rollout is
+			// observed from the status, so that the stats are reset whenever the rollout starts
+			// again, including by another replica.
+			start := now
+			if current != nil && current.TargetModel == model.Spec.Rollout.TargetModel {
+				start = current.StartTime.Time
+			}
+			c.tracker.StartWindow(model.Spec.ModelName, start)
+			stats = c.tracker.Stats(model.Spec.ModelName, model.Spec.Rollout.TargetModel)
+		}
+		want := nextStatus(model, stats, now)
+		if equality.Semantic.DeepEqual(current, want) {
+			continue
+		}
+		klog.V(2).Infof("Updating rollout of model %v: %+v", model.Spec.ModelName, want)
+		updated := model.DeepCopy()
+		updated.Status.Rollout = want
+		// On conflict, the rollout is advanced again in the next reconciliation, with the latest
+		// InferenceModel.
+		if err := c.client.Status().Update(ctx, updated); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("updating rollout status of model %v: %w", model.Spec.ModelName, err))
+		}
+	}
+	return errs
+}
+
+// nextStatus returns the rollout status of the model given the stats of the rollout target model
+// since the rollout started.
+func nextStatus(model *v1alpha1.InferenceModel, stats Stats, now time.Time) *v1alpha1.RolloutStatus {
+	rollout := model.Spec.Rollout
+	if rollout == nil || len(rollout.Steps) == 0 {
+		return nil
+	}
+	current := model.Status.Rollout
+	if current == nil || current.TargetModel != rollout.TargetModel {
+		return &v1alpha1.RolloutStatus{
+			TargetModel: rollout.TargetModel,
+			Phase:       v1alpha1.RolloutProgressing,
+			Step:        0,
+			Percent:     rollout.Steps[0].Percent,
+			StartTime:   metav1.NewTime(now),
+		}
+	}
+	if current.Phase != v1alpha1.RolloutProgressing {
+		return current
+	}
+
+	next := current.DeepCopy()
+	if reason := exceededThreshold(rollout, stats); reason != "" {
+		next.Phase = v1alpha1.RolloutRolledBack
+		next.Percent = 0
+		next.Message = reason
+		return next
+	}
+	elapsed := now.Sub(current.StartTime.Time)
+	for i, step := range rollout.Steps {
+		if elapsed < step.Duration.Duration {
+			next.Step = int32(i)
+			next.Percent = step.Percent
+			return next
+		}
+		elapsed -= step.Duration.Duration
+	}
+	last := len(rollout.Steps) - 1
+	next.Phase = v1alpha1.RolloutSucceeded
+	next.Step = int32(last)
+	next.Percent = rollout.Steps[last].Percent
+	next.Message = ""
+	return next
+}
+
+// exceededThreshold returns why the stats of the rollout target model exceed the thresholds of the
+// rollout, or an empty string if they don't.
+func exceededThreshold(rollout *v1alpha1.ModelRollout, stats Stats) string {
+	minRequests := int64(rollout.MinRequests)
+	if minRequests == 0 {
+		minRequests = defaultMinRequests
+	}
+	if stats.Requests < minRequests {
+		return ""
+	}
+	if rollout.MaxErrorPercent != nil && stats.ErrorPercent() > float64(*rollout.MaxErrorPercent) {
+		return fmt.Sprintf("error rate of %.1f%% over %d requests exceeds %d%%", stats.ErrorPercent(), stats.Requests, *rollout.MaxErrorPercent)
+	}
+	if rollout.MaxLatency != nil && stats.AverageLatency() > rollout.MaxLatency.Duration {
+		return fmt.Sprintf("average latency of %v over %d requests exceeds %v", stats.AverageLatency(), stats.Requests, rollout.MaxLatency.Duration)
+	}
+	return ""
+}
@@ -0,0 +1,219 @@
+package rollout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+)
+
+func TestNextStatus(t *testing.T) {
+	start := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+	maxErrorPercent := int32(10)
+	rollout := &v1alpha1.ModelRollout{
+		TargetModel: "v2",
+		Steps: []v1alpha1.RolloutStep{
+			{Percent: 10, Duration: metav1.Duration{Duration: time.Minute}},
+			{Percent: 50, Duration: metav1.Duration{Duration: time.Minute}},
+		},
+		MaxErrorPercent: &maxErrorPercent,
+		MaxLatency:      &metav1.Duration{Duration: time.Second},
+		MinRequests:     10,
+	}
+	progressing := func(step, percent int32) *v1alpha1.RolloutStatus {
+		return &v1alpha1.RolloutStatus{
+			TargetModel: "v2",
+			Phase:       v1alpha1.RolloutProgressing,
+			Step:        step,
+			Percent:     percent,
+			StartTime:   metav1.NewTime(start),
+		}
+	}
+	tests := []struct {
+		name    string
+		rollout *v1alpha1.ModelRollout
+		current *v1alpha1.RolloutStatus
+		stats   Stats
+		now     time.Time
+		want    *v1alpha1.RolloutStatus
+	}{
+		{
+			name:    "no rollout",
+			current: progressing(0, 10),
+			now:     start,
+			want:    nil,
+		},
+		{
+			name:    "rollout starts",
+			rollout: rollout,
+			now:     start,
+			want:    progressing(0, 10),
+		},
+		{
+			name:    "rollout of another target model starts",
+			rollout: rollout,
+			current: &v1alpha1.RolloutStatus{
+				TargetModel: "v1",
+				Phase:       v1alpha1.RolloutSucceeded,
+				Percent:     100,
+				StartTime:   metav1.NewTime(start.Add(-time.Hour)),
+			},
+			now:  start,
+			want: progressing(0, 10),
+		},
+		{
+			name:    "first step in progress",
+			rollout: rollout,
+			current: progressing(0, 10),
+			stats:   Stats{Requests: 100, Errors: 1, TotalLatency: 50 * time.Second},
+			now:     start.Add(30 * time.Second),
+			want:    progressing(0, 10),
+		},
+		{
+			name:    "second step",
+			rollout: rollout,
+			current: progressing(0, 10),
+			now:     start.Add(90 * time.Second),
+			want:    progressing(1, 50),
+		},
+		{
+			name:    "rollout succeeds",
+			rollout: rollout,
+			current: progressing(1, 50),
+			now:     start.Add(2 * time.Minute),
+			want: &v1alpha1.RolloutStatus{
+				TargetModel: "v2",
+				Phase:       v1alpha1.RolloutSucceeded,
+				Step:        1,
+				Percent:     50,
+				StartTime:   metav1.NewTime(start),
+			},
+		},
+		{
+			name:    "errors below min requests are ignored",
+			rollout: rollout,
+			current: progressing(0, 10),
+			stats:   Stats{Requests: 9, Errors: 9},
+			now:     start.Add(30 * time.Second),
+			want:    progressing(0, 10),
+		},
+		{
+			name:    "rolled back on errors",
+			rollout: rollout,
+			current: progressing(1, 50),
+			stats:   Stats{Requests: 10, Errors: 2},
+			now:     start.Add(90 * time.Second),
+			want: &v1alpha1.RolloutStatus{
+				TargetModel: "v2",
+				Phase:       v1alpha1.RolloutRolledBack,
+				Step:        1,
+				Percent:     0,
+				StartTime:   metav1.NewTime(start),
+				Message:     "error rate of 20.0% over 10 requests exceeds 10%",
+			},
+		},
+		{
+			name:    "rolled back on latency",
+			rollout: rollout,
+			current: progressing(0, 10),
+			stats:   Stats{Requests: 10, TotalLatency: 20 * time.Second},
+			now:     start.Add(30 * time.Second),
+			want: &v1alpha1.RolloutStatus{
+				TargetModel: "v2",
+				Phase:       v1alpha1.RolloutRolledBack,
+				Step:        0,
+				Percent:     0,
+				StartTime:   metav1.NewTime(start),
+				Message:     "average latency of 2s over 10 requests exceeds 1s",
+			},
+		},
+		{
+			name:    "rolled back rollout stays rolled back",
+			rollout: rollout,
+			current: &v1alpha1.RolloutStatus{
+				TargetModel: "v2",
+				Phase:       v1alpha1.RolloutRolledBack,
+				StartTime:   metav1.NewTime(start),
+			},
+			now: start.Add(time.Hour),
+			want: &v1alpha1.RolloutStatus{
+				TargetModel: "v2",
+				Phase:       v1alpha1.RolloutRolledBack,
+				StartTime:   metav1.NewTime(start),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			model := &v1alpha1.InferenceModel{
+				Spec:   v1alpha1.InferenceModelSpec{Rollout: test.rollout},
+				Status: v1alpha1.InferenceModelStatus{Rollout: test.current},
+			}
+			got := nextStatus(model, test.stats, test.now)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Unexpected output (-want +got): %v", diff)
+			}
+		})
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tracker := NewTracker()
+	tracker.RecordResponse("model", "v2", 200, time.Second)
+	tracker.RecordResponse("model", "v2", 503, 3*time.Second)
+	tracker.RecordResponse("model", "v1", 200, time.Second)
+	tracker.RecordResponse("other", "v2", 200, time.Second)
+
+	got := tracker.Stats("model", "v2")
+	if diff := cmp.Diff(Stats{Requests: 2, Errors: 1, TotalLatency: 4 * time.Second}, got); diff != "" {
+		t.Errorf("Unexpected stats (-want +got): %v", diff)
+	}
+	if got.ErrorPercent() != 50 || got.AverageLatency() != 2*time.Second {
+		t.Errorf("Unexpected error percent %v or average latency %v", got.ErrorPercent(), got.AverageLatency())
+	}
+
+	tracker.Reset("model")
+	if got := tracker.Stats("model", "v2"); got.Requests != 0 {
+		t.Errorf("Expected no requests after reset, got %+v", got)
+	}
+	if got := tracker.Stats("other", "v2"); got.Requests != 1 {
+		t.Errorf("Expected the stats of other models to be kept, got %+v", got)
+	}
+}
+
+func TestTrackerWindow(t *testing.T) {
+	start := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+	now := start.Add(-time.Second)
+	tracker := NewTracker()
+	tracker.now = func() time.Time { return now }
+	tracker.RecordResponse("model", "v2", 503, time.Second)
+	tracker.StartWindow("model", start)
+	if got := tracker.Stats("model", "v2"); got.Requests != 0 {
+		t.Errorf("Expected no requests when the window starts, got %+v", got)
+	}
+
+	// Responses before the start of the window, e.g. observed by a replica whose clock is behind,
+	// are not recorded.
+	tracker.RecordResponse("model", "v2", 503, time.Second)
+	now = start.Add(time.Second)
+	tracker.RecordResponse("model", "v2", 200, time.Second)
+	want := Stats{Requests: 1, TotalLatency: time.Second}
+	if diff := cmp.Diff(want, tracker.Stats("model", "v2")); diff != "" {
+		t.Errorf("Unexpected stats (-want +got): %v", diff)
+	}
+
+	// The stats are kept while the window does not change.
+	tracker.StartWindow("model", start)
+	if diff := cmp.Diff(want, tracker.Stats("model", "v2")); diff != "" {
+		t.Errorf("Unexpected stats (-want +got): %v", diff)
+	}
+
+	// The rollout restarted.
+	tracker.StartWindow("model", now)
+	if got := tracker.Stats("model", "v2"); got.Requests != 0 {
+		t.Errorf("Expected no requests after the window restarted, got %+v", got)
+	}
+}
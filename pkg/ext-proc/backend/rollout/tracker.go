@@ -0,0 +1,101 @@
+package rollout
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats are the observed outcomes of the requests to a target model.
+type Stats struct {
+	Requests int64
+	// Errors is the number of requests which failed with a 5xx status code.
+	Errors       int64
+	TotalLatency time.Duration
+}
+
+// ErrorPercent returns the percentage of failed requests.
+func (s Stats) ErrorPercent() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Errors) * 100 / float64(s.Requests)
+}
+
+// AverageLatency returns the average latency of the requests.
+func (s Stats) AverageLatency() time.Duration {
+	if s.Requests == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Requests)
+}
+
+type targetKey struct {
+	model       string
+	targetModel string
+}
+
+// Tracker records the outcome of the requests to each target model of each model.
+type Tracker struct {
+	mu    sync.Mutex
+	stats map[targetKey]Stats
+	// windowStarts are the start times of the windows the stats of the models are recorded over.
+	windowStarts map[string]time.Time
+	now          func() time.Time
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{stats: make(map[targetKey]Stats), windowStarts: make(map[string]time.Time), now: time.Now}
+}
+
+// RecordResponse records the response of the target model to a request to the model. Responses
+// before the start of the window of the model are not recorded.
+func (t *Tracker) RecordResponse(model, targetModel string, statusCode int, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if start, ok := t.windowStarts[model]; ok && t.now().Before(start) {
+		return
+	}
+	key := targetKey{model: model, targetModel: targetModel}
+	s := t.stats[key]
+	s.Requests++
+	if statusCode >= 500 {
+		s.Errors++
+	}
+	s.TotalLatency += latency
+	t.stats[key] = s
+}
+
+// Stats returns the stats of the target model of the model since the last reset or the start of its
+// window.
+func (t *Tracker) Stats(model, targetModel string) Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats[targetKey{model: model, targetModel: targetModel}]
+}
+
+// StartWindow records the stats of the model over a window starting at the time. If the start of
+// the window changes, the stats of the model are discarded.
+func (t *Tracker) StartWindow(model string, start time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if current, ok := t.windowStarts[model]; ok && current.Equal(start) {
+		return
+	}
+	t.windowStarts[model] = start
+	t.reset(model)
+}
+
+// Reset discards the stats of all the target models of the model.
+func (t *Tracker) Reset(model string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reset(model)
+}
+
+func (t *Tracker) reset(model string) {
+	for key := range t.stats {
+		if key.model == model {
+			delete(t.stats, key)
+		}
+	}
+}
@@ -23,6 +23,8 @@ type Metrics struct {
 	WaitingQueueSize        int
 	KVCacheUsagePercent     float64
 	KvCacheMaxTokenCapacity int
+	// Trend is the smoothed values and the trends of the metrics, computed by the Provider.
+	Trend Trend
 }
 
 type PodMetrics struct {
@@ -43,10 +45,12 @@ func (pm *PodMetrics) Clone() *PodMetrics {
 		Pod: pm.Pod,
 		Metrics: Metrics{
 			ActiveModels:            cm,
+			MaxActiveModels:         pm.MaxActiveModels,
 			RunningQueueSize:        pm.RunningQueueSize,
 			WaitingQueueSize:        pm.WaitingQueueSize,
 			KVCacheUsagePercent:     pm.KVCacheUsagePercent,
 			KvCacheMaxTokenCapacity: pm.KvCacheMaxTokenCapacity,
+			Trend:                   pm.Trend,
 		},
 	}
 	return clone
@@ -0,0 +1,75 @@
+package vllm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	klog "k8s.io/klog/v2"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+)
+
+const (
+	loadLoraAdapterPath   = "/v1/load_lora_adapter"
+	unloadLoraAdapterPath = "/v1/unload_lora_adapter"
+)
+
+// AdapterClientImpl loads and unloads LoRA adapters on vLLM model servers, see
+// https://docs.vllm.ai/en/latest/usage/lora.html#dynamically-serving-lora-adapters.
+// vLLM must be started with VLLM_ALLOW_RUNTIME_LORA_UPDATING=True.
+type AdapterClientImpl struct {
+	// Client is the HTTP client used to call the model servers. http.DefaultClient is used if nil.
+	Client *http.Client
+}
+
+type loadLoraAdapterRequest struct {
+	LoraName string `json:"lora_name"`
+	LoraPath string `json:"lora_path"`
+}
+
+type unloadLoraAdapterRequest struct {
+	LoraName string `json:"lora_name"`
+}
+
+// LoadAdapter loads the adapter with the given name from source to the pod.
+func (c *AdapterClientImpl) LoadAdapter(ctx context.Context, pod backend.Pod, name, source string) error {
+	return c.post(ctx, pod, loadLoraAdapterPath, loadLoraAdapterRequest{LoraName: name, LoraPath: source})
+}
+
+// UnloadAdapter unloads the adapter with the given name from the pod.
+func (c *AdapterClientImpl) UnloadAdapter(ctx context.Context, pod backend.Pod, name string) error {
+	return c.post(ctx, pod, unloadLoraAdapterPath, unloadLoraAdapterRequest{LoraName: name})
+}
+
+func (c *AdapterClientImpl) post(ctx context.Context, pod backend.Pod, path string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+	url := fmt.Sprintf("http://%s%s", pod.Address, path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s on %s: %w", path, pod, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status code from %s on %s: %v, %s", path, pod, resp.StatusCode, msg)
+	}
+	klog.V(4).Infof("Called %s on %s with %s", path, pod, body)
+	return nil
+}
@@ -0,0 +1,45 @@
+package vllm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+)
+
+func TestAdapterClient(t *testing.T) {
+	var gotPath string
+	var gotBody map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotBody = nil
+		if err := json.NewDecoder(r.Body).Decode(&gotBody); err != nil {
+			t.Errorf("Failed to decode request body: %v", err)
+		}
+		if gotBody["lora_name"] == "missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	pod := backend.Pod{Name: "pod", Address: strings.TrimPrefix(srv.URL, "http://")}
+	c := &AdapterClientImpl{}
+
+	err := c.LoadAdapter(context.Background(), pod, "sql-lora", "/adapters/sql-lora")
+	assert.NoError(t, err)
+	assert.Equal(t, loadLoraAdapterPath, gotPath)
+	assert.Equal(t, map[string]string{"lora_name": "sql-lora", "lora_path": "/adapters/sql-lora"}, gotBody)
+
+	err = c.UnloadAdapter(context.Background(), pod, "sql-lora")
+	assert.NoError(t, err)
+	assert.Equal(t, unloadLoraAdapterPath, gotPath)
+	assert.Equal(t, map[string]string{"lora_name": "sql-lora"}, gotBody)
+
+	err = c.UnloadAdapter(context.Background(), pod, "missing")
+	assert.Error(t, err)
+}
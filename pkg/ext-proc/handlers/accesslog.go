@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	klog "k8s.io/klog/v2"
+)
+
+// redactedValue replaces the values of the redacted access log fields.
+const redactedValue = "REDACTED"
+
+// AccessLogEntry is the access log record of a request.
+type AccessLogEntry struct {
+	// Time is when the request headers were received.
+	Time        time.Time `json:"time"`
+	Model       string    `json:"model"`
+	TargetModel string    `json:"target_model,omitempty"`
+	Criticality string    `json:"criticality,omitempty"`
+	Pod         string    `json:"pod,omitempty"`
+	SessionID   string    `json:"session_id,omitempty"`
+	// TrafficSplitKey is the value of the traffic split header, e.g. a user ID.
+	TrafficSplitKey  string `json:"traffic_split_key,omitempty"`
+	StatusCode       int    `json:"status_code,omitempty"`
+	Error            string `json:"error,omitempty"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	// QueueWaitMs is the time from receiving the request headers to picking the target pod.
+	QueueWaitMs int64 `json:"queue_wait_ms"`
+	// ResponseLatencyMs is the time from receiving the request headers to receiving the response
+	// headers.
+	ResponseLatencyMs int64 `json:"response_latency_ms"`
+	// DurationMs is the time from receiving the request headers to the end of the stream.
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// redactableFields maps the names of the access log fields which can be redacted to the fields.
+var redactableFields = map[string]func(e *AccessLogEntry) *string{
+	"model":             func(e *AccessLogEntry) *string { return &e.Model },
+	"target_model":      func(e *AccessLogEntry) *string { return &e.TargetModel },
+	"pod":               func(e *AccessLogEntry) *string { return &e.Pod },
+	"session_id":        func(e *AccessLogEntry) *string { return &e.SessionID },
+	"traffic_split_key": func(e *AccessLogEntry) *string { return &e.TrafficSplitKey },
+	"error":             func(e *AccessLogEntry) *string { return &e.Error },
+}
+
+// AccessLogSink writes access log entries, e.g. to a file.
+type AccessLogSink interface {
+	Write(entry *AccessLogEntry) error
+}
+
+// AccessLogger records an access log entry for the requests to a sink.
+type AccessLogger struct {
+	sink AccessLogSink
+	// sampleRate is the fraction of the successful requests which are logged. Failed requests are
+	// always logged.
+	sampleRate float64
+	// redacted are the fields whose values are replaced by redactedValue.
+	redacted []func(e *AccessLogEntry) *string
+}
+
+// NewAccessLogger returns an access logger logging the given fraction, between 0 and 1, of the
+// successful requests and all the failed requests to the sink. The values of the redacted fields,
+// among "model", "target_model", "pod", "session_id", "traffic_split_key", and "error", are
+// replaced in the entries.
+func NewAccessLogger(sink AccessLogSink, sampleRate float64, redactedFields []string) (*AccessLogger, error) {
+	if sampleRate < 0 || sampleRate > 1 {
+		return nil, fmt.Errorf("access log sample rate %v is not between 0 and 1", sampleRate)
+	}
+	l := &AccessLogger{sink: sink, sampleRate: sampleRate}
+	for _, field := range redactedFields {
+		f, ok := redactableFields[field]
+		if !ok {
+			return nil, fmt.Errorf("access log field %q cannot be redacted", field)
+		}
+		l.redacted = append(l.redacted, f)
+	}
+	return l, nil
+}
+
+// Log writes the access log entry of the request to the sink, if it is sampled.
+func (l *AccessLogger) Log(reqCtx *RequestContext, now time.Time) {
+	failed := reqCtx.Err != nil || reqCtx.StatusCode >= 400
+	if !failed && rand.Float64() >= l.sampleRate {
+		return
+	}
+	entry := newAccessLogEntry(reqCtx, now)
+	for _, f := range l.redacted {
+		if field := f(entry); *field != "" {
+			*field = redactedValue
+		}
+	}
+	if err := l.sink.Write(entry); err != nil {
+		klog.Errorf("Failed to write access log entry: %v", err)
+	}
+}
+
+func newAccessLogEntry(reqCtx *RequestContext, now time.Time) *AccessLogEntry {
+	entry := &AccessLogEntry{
+		Time:              reqCtx.RequestReceivedTimestamp,
+		Model:             reqCtx.Model,
+		TargetModel:       reqCtx.ResolvedTargetModel,
+		Criticality:       string(reqCtx.Criticality),
+		Pod:               reqCtx.TargetPod.Name,
+		SessionID:         reqCtx.SessionID,
+		TrafficSplitKey:   reqCtx.TrafficSplitKey,
+		StatusCode:        reqCtx.StatusCode,
+		PromptTokens:      reqCtx.Response.Usage.PromptTokens,
+		CompletionTokens:  reqCtx.Response.Usage.CompletionTokens,
+		QueueWaitMs:       reqCtx.QueueWait.Milliseconds(),
+		ResponseLatencyMs: reqCtx.ResponseLatency.Milliseconds(),
+		DurationMs:        now.Sub(reqCtx.RequestReceivedTimestamp).Milliseconds(),
+	}
+	if reqCtx.Err != nil {
+		entry.Error = reqCtx.Err.Error()
+	}
+	return entry
+}
+
+// logAccess records the access log entry of the request, if access logging is enabled and the
+// request was received.
+func (s *Server) logAccess(reqCtx *RequestContext) {
+	if s.accessLogger == nil || reqCtx.RequestReceivedTimestamp.IsZero() {
+		return
+	}
+	s.accessLogger.Log(reqCtx, time.Now())
+}
+
+// JSONLinesSink writes access log entries as JSON lines.
+type JSONLinesSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONLinesSink returns a sink writing access log entries as JSON lines to w, e.g. os.Stdout.
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{w: w}
+}
+
+// OpenAccessLogFile returns a sink appending access log entries as JSON lines to the file, which
+// is created if it does not exist.
+func OpenAccessLogFile(path string) (*JSONLinesSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONLinesSink(f), nil
+}
+
+func (s *JSONLinesSink) Write(entry *AccessLogEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(line)
+	return err
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+)
+
+func TestAccessLogger(t *testing.T) {
+	received := time.Date(2024, 11, 25, 19, 42, 45, 0, time.UTC)
+	served := &RequestContext{
+		RequestReceivedTimestamp: received,
+		Model:                    "my-model",
+		ResolvedTargetModel:      "my-model-v1",
+		Criticality:              "Critical",
+		TargetPod:                backend.Pod{Name: "pod1", Address: "address-1"},
+		SessionID:                "session-1",
+		StatusCode:               200,
+		QueueWait:                5 * time.Millisecond,
+		ResponseLatency:          200 * time.Millisecond,
+		Response:                 Response{Usage: Usage{PromptTokens: 11, CompletionTokens: 100, TotalTokens: 111}},
+	}
+	shed := &RequestContext{
+		RequestReceivedTimestamp: received,
+		Model:                    "my-model",
+		ResolvedTargetModel:      "my-model-v1",
+		Criticality:              "Sheddable",
+		StatusCode:               429,
+		Err:                      status.Error(codes.ResourceExhausted, "dropping request"),
+	}
+	tests := []struct {
+		name           string
+		reqCtx         *RequestContext
+		sampleRate     float64
+		redactedFields []string
+		want           []AccessLogEntry
+	}{
+		{
+			name:       "served request",
+			reqCtx:     served,
+			sampleRate: 1,
+			want: []AccessLogEntry{
+				{
+					Time:              received,
+					Model:             "my-model",
+					TargetModel:       "my-model-v1",
+					Criticality:       "Critical",
+					Pod:               "pod1",
+					SessionID:         "session-1",
+					StatusCode:        200,
+					PromptTokens:      11,
+					CompletionTokens:  100,
+					QueueWaitMs:       5,
+					ResponseLatencyMs: 200,
+					DurationMs:        1000,
+				},
+			},
+		},
+		{
+			name:       "served request not sampled",
+			reqCtx:     served,
+			sampleRate: 0,
+		},
+		{
+			name:       "failed requests are always logged",
+			reqCtx:     shed,
+			sampleRate: 0,
+			want: []AccessLogEntry{
+				{
+					Time:        received,
+					Model:       "my-model",
+					TargetModel: "my-model-v1",
+					Criticality: "Sheddable",
+					StatusCode:  429,
+					Error:       "rpc error: code = ResourceExhausted desc = dropping request",
+					DurationMs:  1000,
+				},
+			},
+		},
+		{
+			name:           "redacted fields",
+			reqCtx:         served,
+			sampleRate:     1,
+			redactedFields: []string{"session_id", "pod", "error"},
+			want: []AccessLogEntry{
+				{
+					Time:              received,
+					Model:             "my-model",
+					TargetModel:       "my-model-v1",
+					Criticality:       "Critical",
+					Pod:               redactedValue,
+					SessionID:         redactedValue,
+					StatusCode:        200,
+					PromptTokens:      11,
+					CompletionTokens:  100,
+					QueueWaitMs:       5,
+					ResponseLatencyMs: 200,
+					DurationMs:        1000,
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			l, err := NewAccessLogger(NewJSONLinesSink(&buf), test.sampleRate, test.redactedFields)
+			if err != nil {
+				t.Fatalf("NewAccessLogger: %v", err)
+			}
+			l.Log(test.reqCtx, received.Add(time.Second))
+
+			var got []AccessLogEntry
+			for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+				if line == "" {
+					continue
+				}
+				var entry AccessLogEntry
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Fatalf("Invalid access log line %q: %v", line, err)
+				}
+				got = append(got, entry)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Unexpected access log entries (-want +got): %v", diff)
+			}
+		})
+	}
+}
+
+func TestNewAccessLoggerInvalidSettings(t *testing.T) {
+	if _, err := NewAccessLogger(NewJSONLinesSink(&bytes.Buffer{}), 1.5, nil); err == nil {
+		t.Error("Expected an error for a sample rate above 1")
+	}
+	if _, err := NewAccessLogger(NewJSONLinesSink(&bytes.Buffer{}), 1, []string{"status_code"}); err == nil {
+		t.Error("Expected an error for a field which cannot be redacted")
+	}
+}
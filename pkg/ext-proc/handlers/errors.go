@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+
+	configPb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	envoyTypePb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	klog "k8s.io/klog/v2"
+)
+
+// errorMapping describes the HTTP response returned to the client for errors with a gRPC code.
+type errorMapping struct {
+	httpStatus envoyTypePb.StatusCode
+	// errorType and errorCode are the "type" and "code" of the OpenAI compatible error.
+	errorType string
+	errorCode string
+}
+
+var (
+	// errorMappings maps the gRPC codes of errors returned while handling a request to the response
+	// returned to the client. Errors with other codes are returned as internal server errors.
+	errorMappings = map[codes.Code]errorMapping{
+		// The request body is malformed.
+		codes.InvalidArgument: {envoyTypePb.StatusCode_BadRequest, "invalid_request_error", "invalid_request"},
+		// The request body exceeds a size limit.
+		codes.OutOfRange: {envoyTypePb.StatusCode_PayloadTooLarge, "invalid_request_error", "request_too_large"},
+		// The requested model is unknown or has no valid target model.
+		codes.NotFound: {envoyTypePb.StatusCode_NotFound, "invalid_request_error", "model_not_found"},
+		// The request was shed, or the client exceeded a limit.
+		codes.ResourceExhausted: {envoyTypePb.StatusCode_TooManyRequests, "server_overloaded", "capacity_exceeded"},
+		// Envoy sent the request body in a mode the requests cannot be routed in.
+		codes.FailedPrecondition: {envoyTypePb.StatusCode_InternalServerError, "server_error", "unsupported_body_mode"},
+		// There are no model servers to send the request to.
+		codes.Unavailable: {envoyTypePb.StatusCode_ServiceUnavailable, "server_error", "no_backend_available"},
+	}
+	internalErrorMapping = errorMapping{envoyTypePb.StatusCode_InternalServerError, "server_error", "internal_error"}
+
+	// errorMessages are the messages returned to the client per OpenAI error code. The messages of
+	// the errors themselves are only logged, since they may expose internal details such as the
+	// pods of the pool.
+	errorMessages = map[string]string{
+		"invalid_request":         "The request is invalid.",
+		"request_too_large":       "The request body is too large.",
+		"model_not_found":         "The requested model does not exist.",
+		"capacity_exceeded":       "The model servers are overloaded, please retry later.",
+		"no_backend_available":    "No model server is available, please retry later.",
+		"internal_error":          "The server had an error while processing the request.",
+		"unsupported_parameter":   "The request has parameters which are not allowed for the model.",
+		"context_length_exceeded": "The prompt exceeds the maximum length allowed for the model.",
+		"invalid_value":           "The request has a parameter value exceeding the limit of the model.",
+		"rate_limit_exceeded":     "The token quota of the model is exhausted, please retry later.",
+		"unsupported_body_mode":   "The gateway is not configured to route request bodies sent in chunks.",
+	}
+)
+
+// errorMappingFor returns how the error is returned to the client.
+func errorMappingFor(err error) errorMapping {
+	if mapping, ok := errorMappings[status.Code(err)]; ok {
+		return mapping
+	}
+	return internalErrorMapping
+}
+
+// codedError overrides the OpenAI error code of the error it wraps.
+type codedError struct {
+	error
+	code string
+}
+
+// GRPCStatus returns the status of the wrapped error, so that the error keeps its gRPC code.
+func (e *codedError) GRPCStatus() *status.Status {
+	return status.Convert(e.error)
+}
+
+func (e *codedError) Unwrap() error {
+	return e.error
+}
+
+// withErrorCode overrides the OpenAI error code the error is returned to the client with, e.g. to
+// tell apart errors with the same gRPC code.
+func withErrorCode(err error, code string) error {
+	return &codedError{error: err, code: code}
+}
+
+// openAIError is the body of OpenAI API errors, which clients using OpenAI SDKs can parse.
+type openAIError struct {
+	Error openAIErrorDetails `json:"error"`
+}
+
+type openAIErrorDetails struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+}
+
+// errorResponse converts an error returned while handling a request to an ImmediateResponse with
+// the HTTP status matching the gRPC code of the error and an OpenAI compatible JSON body, whose
+// message only depends on the error code.
+func errorResponse(err error) *extProcPb.ProcessingResponse {
+	mapping := errorMappingFor(err)
+	details := openAIErrorDetails{
+		Type: mapping.errorType,
+		Code: mapping.errorCode,
+	}
+	var coded *codedError
+	if errors.As(err, &coded) {
+		details.Code = coded.code
+	}
+	details.Message = errorMessages[details.Code]
+	body, marshalErr := json.Marshal(openAIError{Error: details})
+	if marshalErr != nil {
+		klog.Errorf("Error marshaling error body: %v", marshalErr)
+	}
+	return &extProcPb.ProcessingResponse{
+		Response: &extProcPb.ProcessingResponse_ImmediateResponse{
+			ImmediateResponse: &extProcPb.ImmediateResponse{
+				Status: &envoyTypePb.HttpStatus{
+					Code: mapping.httpStatus,
+				},
+				Headers: &extProcPb.HeaderMutation{
+					SetHeaders: []*configPb.HeaderValueOption{
+						{
+							Header: &configPb.HeaderValue{
+								Key:      "content-type",
+								RawValue: []byte("application/json"),
+							},
+						},
+					},
+				},
+				Body: body,
+			},
+		},
+	}
+}
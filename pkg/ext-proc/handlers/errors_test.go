@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	envoyTypePb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus envoyTypePb.StatusCode
+		wantBody   string
+	}{
+		{
+			name:       "bad request",
+			err:        status.Errorf(codes.InvalidArgument, "model not found in request"),
+			wantStatus: envoyTypePb.StatusCode_BadRequest,
+			wantBody:   `{"error":{"message":"The request is invalid.","type":"invalid_request_error","code":"invalid_request"}}`,
+		},
+		{
+			name:       "unknown model",
+			err:        status.Errorf(codes.NotFound, "the model `foo` does not exist"),
+			wantStatus: envoyTypePb.StatusCode_NotFound,
+			wantBody:   `{"error":{"message":"The requested model does not exist.","type":"invalid_request_error","code":"model_not_found"}}`,
+		},
+		{
+			name:       "no capacity",
+			err:        fmt.Errorf("failed to find target pod: %w", status.Errorf(codes.ResourceExhausted, "dropping request")),
+			wantStatus: envoyTypePb.StatusCode_TooManyRequests,
+			wantBody:   `{"error":{"message":"The model servers are overloaded, please retry later.","type":"server_overloaded","code":"capacity_exceeded"}}`,
+		},
+		{
+			name:       "no pods",
+			err:        status.Errorf(codes.Unavailable, "no pods available in the pool"),
+			wantStatus: envoyTypePb.StatusCode_ServiceUnavailable,
+			wantBody:   `{"error":{"message":"No model server is available, please retry later.","type":"server_error","code":"no_backend_available"}}`,
+		},
+		{
+			name:       "internal error",
+			err:        errors.New("oops"),
+			wantStatus: envoyTypePb.StatusCode_InternalServerError,
+			wantBody:   `{"error":{"message":"The server had an error while processing the request.","type":"server_error","code":"internal_error"}}`,
+		},
+		{
+			name:       "error with a code",
+			err:        withErrorCode(status.Errorf(codes.InvalidArgument, "prompt of 1000 characters exceeds the limit of 10 characters"), "context_length_exceeded"),
+			wantStatus: envoyTypePb.StatusCode_BadRequest,
+			wantBody:   `{"error":{"message":"The prompt exceeds the maximum length allowed for the model.","type":"invalid_request_error","code":"context_length_exceeded"}}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := errorResponse(test.err).GetImmediateResponse()
+			if resp.GetStatus().GetCode() != test.wantStatus {
+				t.Errorf("Unexpected status, got %v, want %v", resp.GetStatus().GetCode(), test.wantStatus)
+			}
+			if string(resp.GetBody()) != test.wantBody {
+				t.Errorf("Unexpected body, got %s, want %s", resp.GetBody(), test.wantBody)
+			}
+			header := resp.GetHeaders().GetSetHeaders()[0].GetHeader()
+			if header.GetKey() != "content-type" || string(header.GetRawValue()) != "application/json" {
+				t.Errorf("Unexpected header %v", header)
+			}
+		})
+	}
+}
+
+func TestErrorMessages(t *testing.T) {
+	for code, mapping := range errorMappings {
+		if errorMessages[mapping.errorCode] == "" {
+			t.Errorf("No message for the error code %q of gRPC code %v", mapping.errorCode, code)
+		}
+	}
+	if errorMessages[internalErrorMapping.errorCode] == "" {
+		t.Errorf("No message for the internal error code %q", internalErrorMapping.errorCode)
+	}
+}
@@ -1,82 +1,133 @@
 package handlers
 
 import (
-	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
+	"time"
 
 	configPb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	klog "k8s.io/klog/v2"
 
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
 	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/jsonbody"
 	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/scheduling"
 )
 
+// errChunkedBody is returned for the request bodies Envoy sends in chunks.
+var errChunkedBody = status.Error(codes.FailedPrecondition,
+	"request body received in chunks, configure Envoy to send the request bodies in the BUFFERED mode")
+
 // HandleRequestBody handles body of the request to the backend server, such as parsing the "model"
 // parameter.
 // Envoy sends the request body to ext proc before sending the request to the backend server.
+//
+// Requests are routed by the target pod header set in the response to the body, so Envoy must hold
+// back the request headers until then, and send the whole body at once, i.e. in the BUFFERED mode.
+// In the STREAMED and BUFFERED_PARTIAL modes, the body may be sent in chunks after the headers,
+// which cannot be routed, so these requests are rejected.
 func (s *Server) HandleRequestBody(reqCtx *RequestContext, req *extProcPb.ProcessingRequest) (*extProcPb.ProcessingResponse, error) {
 	klog.V(3).Infof("Handling request body")
 
 	// Unmarshal request body (must be JSON).
 	v := req.Request.(*extProcPb.ProcessingRequest_RequestBody)
-	var rb map[string]interface{}
-	if err := json.Unmarshal(v.RequestBody.Body, &rb); err != nil {
-		klog.Errorf("Error unmarshaling request body: %v", err)
-		return nil, fmt.Errorf("error unmarshaling request body: %v", err)
+	// In the BUFFERED mode, the body is sent in a single message, which only lacks the end of the
+	// stream if trailers follow. Another message is the next chunk of a body sent in chunks.
+	if reqCtx.bodyReceived {
+		return nil, errChunkedBody
 	}
-	klog.V(3).Infof("Request body: %v", rb)
-
-	// Resolve target models.
-	model, ok := rb["model"].(string)
-	if !ok {
-		return nil, fmt.Errorf("model not found in request")
+	reqCtx.bodyReceived = true
+	rawBody := v.RequestBody.Body
+	// Reject large bodies before parsing them.
+	if err := checkBodySize(rawBody, s.maxRequestBodyBytes); err != nil {
+		return nil, err
+	}
+	span := s.startSpan(reqCtx, "parse_body")
+	body, model, err := parseRequestBody(rawBody)
+	endSpan(span, err)
+	if err != nil {
+		if !v.RequestBody.EndOfStream {
+			// The body is likely the first chunk of a body sent in chunks.
+			return nil, errChunkedBody
+		}
+		return nil, err
 	}
 	klog.V(3).Infof("Model requested: %v", model)
-	modelName := model
-
-	// NOTE: The nil checking for the modelObject means that we DO allow passthrough currently.
-	// This might be a security risk in the future where adapters not registered in the InferenceModel
-	// are able to be requested by using their distinct name.
-	modelObj := s.datastore.FetchModelData(model)
-	if modelObj == nil {
-		return nil, fmt.Errorf("error finding a model object in InferenceModel for input %v", model)
-	}
-	if len(modelObj.Spec.TargetModels) > 0 {
-		modelName = backend.RandomWeightedDraw(modelObj, 0)
-		if modelName == "" {
-			return nil, fmt.Errorf("error getting target model name for model %v", modelObj.Name)
+
+	// The session may be used to pick the target model.
+	sessionID := s.sessionID(reqCtx, body)
+	span = s.startSpan(reqCtx, "resolve_model")
+	modelObj, modelName, registered, err := s.resolveTargetModel(reqCtx, model, body)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateRequest(modelObj, body); err != nil {
+		return nil, err
+	}
+	if s.quotaLimiter != nil {
+		reservation, err := s.quotaLimiter.Reserve(reqCtx.context(), modelObj, s.tenant(reqCtx), estimateTokens(body))
+		if err != nil {
+			return nil, withErrorCode(err, "rate_limit_exceeded")
 		}
+		reqCtx.quotaReservation = reservation
 	}
 	llmReq := &scheduling.LLMRequest{
 		Model:               model,
 		ResolvedTargetModel: modelName,
-		Critical:            backend.IsCritical(modelObj),
+		Criticality:         backend.ResolveCriticality(modelObj, reqCtx.RequestedCriticality),
+		SessionID:           sessionID,
+		MaxTokens:           maxTokens(body),
+	}
+	if s.latencyObserver != nil {
+		// The prompt tokens are only used to predict the latency of the request.
+		llmReq.PromptTokens = estimatePromptTokens(body)
 	}
 	klog.V(3).Infof("LLM Request: %+v", llmReq)
-
-	requestBody := v.RequestBody.Body
-	var err error
-	// Update target models in the body.
-	if llmReq.Model != llmReq.ResolvedTargetModel {
-		rb["model"] = llmReq.ResolvedTargetModel
-		requestBody, err = json.Marshal(rb)
-		if err != nil {
-			klog.Errorf("Error marshaling request body: %v", err)
-			return nil, fmt.Errorf("error marshaling request body: %v", err)
-		}
-		klog.V(3).Infof("Updated body: %v", string(requestBody))
+	// The demand for unregistered models, such as the models passed through by the unknown model
+	// policy, is not recorded, since their names are picked by the clients.
+	if s.demandRecorder != nil && registered {
+		s.demandRecorder.RecordRequest(llmReq.ResolvedTargetModel)
 	}
 
-	targetPod, err := s.scheduler.Schedule(llmReq)
+	span = s.startSpan(reqCtx, "schedule")
+	targetPod, err := s.schedule(span, llmReq, modelObj.Spec.Fallbacks)
+	endSpan(span, err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find target pod: %w", err)
 	}
 	klog.V(3).Infof("Selected target model %v in target pod: %v\n", llmReq.ResolvedTargetModel, targetPod)
 
+	requestBody := rawBody
+	// Update target models in the body. Only the model is rewritten, so that large prompts are not
+	// decoded and encoded again, and the other fields keep their order.
+	if llmReq.Model != llmReq.ResolvedTargetModel {
+		requestBody = body.WithString("model", llmReq.ResolvedTargetModel)
+		klog.V(3).Infof("Updated body: %s", requestBody)
+	}
+
 	reqCtx.Model = llmReq.Model
+	reqCtx.ModelName = modelObj.Spec.ModelName
+	reqCtx.ResolvedTargetModel = llmReq.ResolvedTargetModel
 	reqCtx.TargetPod = targetPod
+	reqCtx.Criticality = llmReq.Criticality
+	reqCtx.SchedulingPath = llmReq.Decision.Path()
+	reqCtx.latencyPrediction = llmReq.Prediction
+	if !reqCtx.RequestReceivedTimestamp.IsZero() {
+		reqCtx.QueueWait = time.Since(reqCtx.RequestReceivedTimestamp)
+	}
+	trace.SpanFromContext(reqCtx.context()).SetAttributes(
+		attribute.String("model", reqCtx.Model),
+		attribute.String("target_model", reqCtx.ResolvedTargetModel),
+		attribute.String("criticality", string(reqCtx.Criticality)),
+		attribute.String("pod", targetPod.Name),
+	)
 
 	// Insert "target-pod" to instruct Envoy to route requests to the specified target pod.
 	headers := []*configPb.HeaderValueOption{
@@ -95,6 +146,8 @@ func (s *Server) HandleRequestBody(reqCtx *RequestContext, req *extProcPb.Proces
 			},
 		},
 	}
+	// Propagate the trace context to the model server.
+	headers = append(headers, s.traceContextHeaders(reqCtx)...)
 	// Print headers for debugging
 	for _, header := range headers {
 		klog.V(3).Infof("[request_body] Header Key: %s, Header Value: %s\n", header.Header.Key, header.Header.RawValue)
@@ -119,11 +172,27 @@ func (s *Server) HandleRequestBody(reqCtx *RequestContext, req *extProcPb.Proces
 	return resp, nil
 }
 
-func HandleRequestHeaders(reqCtx *RequestContext, req *extProcPb.ProcessingRequest) *extProcPb.ProcessingResponse {
+func (s *Server) HandleRequestHeaders(reqCtx *RequestContext, req *extProcPb.ProcessingRequest) *extProcPb.ProcessingResponse {
 	klog.V(3).Info("Handling request headers ...")
 	r := req.Request
 	h := r.(*extProcPb.ProcessingRequest_RequestHeaders)
 	klog.V(3).Infof("Headers: %+v\n", h)
+	reqCtx.RequestReceivedTimestamp = time.Now()
+
+	reqCtx.Headers = make(map[string]string, len(h.RequestHeaders.GetHeaders().GetHeaders()))
+	for _, header := range h.RequestHeaders.GetHeaders().GetHeaders() {
+		reqCtx.Headers[strings.ToLower(header.Key)] = headerValue(header)
+		if strings.EqualFold(header.Key, CriticalityHeader) {
+			reqCtx.RequestedCriticality = v1alpha1.Criticality(headerValue(header))
+		}
+		if s.sessionHeader != "" && strings.EqualFold(header.Key, s.sessionHeader) {
+			reqCtx.SessionID = headerValue(header)
+		}
+		if s.trafficSplitHeader != "" && strings.EqualFold(header.Key, s.trafficSplitHeader) {
+			reqCtx.TrafficSplitKey = headerValue(header)
+		}
+	}
+	s.startRequestSpan(reqCtx)
 
 	resp := &extProcPb.ProcessingResponse{
 		Response: &extProcPb.ProcessingResponse_RequestHeaders{
@@ -140,3 +209,154 @@ func HandleRequestHeaders(reqCtx *RequestContext, req *extProcPb.ProcessingReque
 
 	return resp
 }
+
+// fetchModel returns the InferenceModel of the requested model, and whether it is registered. If
+// there is none, the unknown model policy decides whether the request is rejected, passed through
+// to the requested model, or sent to the fallback model.
+func (s *Server) fetchModel(model string) (*v1alpha1.InferenceModel, bool, error) {
+	if modelObj := s.datastore.FetchModelData(model); modelObj != nil {
+		return modelObj, true, nil
+	}
+	switch s.unknownModelPolicy {
+	case PassthroughUnknownModels:
+		// NOTE: Passthrough allows adapters not registered in an InferenceModel to be requested by
+		// using their distinct name. They are served with the default criticality.
+		klog.V(3).Infof("Passing through request for unknown model %v", model)
+		return &v1alpha1.InferenceModel{Spec: v1alpha1.InferenceModelSpec{ModelName: model}}, false, nil
+	case FallbackUnknownModels:
+		klog.V(3).Infof("Sending request for unknown model %v to fallback model %v", model, s.fallbackModel)
+		if modelObj := s.datastore.FetchModelData(s.fallbackModel); modelObj != nil {
+			return modelObj, true, nil
+		}
+		return &v1alpha1.InferenceModel{Spec: v1alpha1.InferenceModelSpec{ModelName: s.fallbackModel}}, false, nil
+	default:
+		return nil, false, status.Errorf(codes.NotFound, "the model `%v` does not exist", model)
+	}
+}
+
+// parseRequestBody locates the top-level fields of the JSON request body, without decoding them,
+// and returns it with the requested model.
+func parseRequestBody(raw []byte) (*jsonbody.Body, string, error) {
+	body, err := jsonbody.Parse(raw)
+	if err != nil {
+		klog.Errorf("Error parsing request body: %v", err)
+		return nil, "", status.Errorf(codes.InvalidArgument, "error parsing request body: %v", err)
+	}
+	klog.V(3).Infof("Request body: %s", raw)
+
+	model, ok := body.String("model")
+	if !ok {
+		return nil, "", status.Errorf(codes.InvalidArgument, "model not found in request")
+	}
+	return body, model, nil
+}
+
+// resolveTargetModel returns the InferenceModel serving the requested model, the target model the
+// request is sent to, and whether the InferenceModel is registered.
+func (s *Server) resolveTargetModel(reqCtx *RequestContext, model string, body *jsonbody.Body) (*v1alpha1.InferenceModel, string, bool, error) {
+	modelObj, registered, err := s.fetchModel(model)
+	if err != nil {
+		return nil, "", false, err
+	}
+	// Rules take precedence over the weights of the target models.
+	modelName := backend.MatchTargetModel(modelObj, reqCtx.Headers, body)
+	if modelName != "" {
+		klog.V(3).Infof("Request for model %v matched a rule for target model %v", model, modelName)
+		return modelObj, modelName, registered, nil
+	}
+	if len(modelObj.Spec.TargetModels) == 0 {
+		return modelObj, modelObj.Spec.ModelName, registered, nil
+	}
+	modelName = s.drawTargetModel(reqCtx, modelObj)
+	if modelName == "" {
+		return nil, "", false, status.Errorf(codes.NotFound, "no valid target model found for model %v", model)
+	}
+	return modelObj, modelName, registered, nil
+}
+
+// tenant returns the tenant the tokens used by the request are accounted to, if any.
+func (s *Server) tenant(reqCtx *RequestContext) string {
+	if s.tenantHeader == "" {
+		return ""
+	}
+	return reqCtx.Headers[strings.ToLower(s.tenantHeader)]
+}
+
+// estimateTokens estimates the tokens a request will use before its response reports them: its
+// prompt tokens, and the maximum number of tokens to generate, if set.
+func estimateTokens(body *jsonbody.Body) int64 {
+	return int64(estimatePromptTokens(body) + maxTokens(body))
+}
+
+// estimatePromptTokens estimates the prompt tokens of a request, as about one token per 4 bytes of
+// the body, without decoding the prompt.
+func estimatePromptTokens(body *jsonbody.Body) int {
+	return len(body.Raw()) / 4
+}
+
+// maxTokens returns the maximum number of tokens to generate for the request, or 0 if not set.
+func maxTokens(body *jsonbody.Body) int {
+	for _, p := range []string{"max_tokens", "max_completion_tokens"} {
+		if v, ok := body.Number(p); ok && v > 0 {
+			return int(v)
+		}
+	}
+	return 0
+}
+
+// schedule picks the target pod of the request. If there is no capacity for the target model of the
+// request, the fallback models are tried in order, and the target model of the request is set to
+// the first one with capacity.
+func (s *Server) schedule(span trace.Span, llmReq *scheduling.LLMRequest, fallbacks []string) (backend.Pod, error) {
+	targetPod, err := s.scheduler.Schedule(llmReq)
+	addDecisionEvents(span, llmReq.Decision)
+	for _, fallback := range fallbacks {
+		if status.Code(err) != codes.ResourceExhausted {
+			break
+		}
+		klog.V(3).Infof("No capacity for target model %v, falling back to %v: %v", llmReq.ResolvedTargetModel, fallback, err)
+		llmReq.ResolvedTargetModel = fallback
+		targetPod, err = s.scheduler.Schedule(llmReq)
+		addDecisionEvents(span, llmReq.Decision)
+	}
+	return targetPod, err
+}
+
+// drawTargetModel picks the target model of the request, consistently for the traffic split key or
+// the session of the request if enabled, and at random otherwise.
+func (s *Server) drawTargetModel(reqCtx *RequestContext, modelObj *v1alpha1.InferenceModel) string {
+	if s.consistentTrafficSplit {
+		key := reqCtx.TrafficSplitKey
+		if key == "" {
+			key = reqCtx.SessionID
+		}
+		if key != "" {
+			return backend.HashWeightedDraw(modelObj, key)
+		}
+	}
+	return backend.RandomWeightedDraw(modelObj, 0)
+}
+
+// sessionID returns the session ID of the request from the session header, falling back to the
+// session body field.
+func (s *Server) sessionID(reqCtx *RequestContext, body *jsonbody.Body) string {
+	if reqCtx.SessionID != "" {
+		return reqCtx.SessionID
+	}
+	if s.sessionBodyField == "" {
+		return ""
+	}
+	if id, ok := body.String(s.sessionBodyField); ok {
+		reqCtx.SessionID = id
+	}
+	return reqCtx.SessionID
+}
+
+// headerValue returns the value of the header. Envoy sets either Value or RawValue depending on
+// its configuration.
+func headerValue(header *configPb.HeaderValue) string {
+	if len(header.RawValue) > 0 {
+		return string(header.RawValue)
+	}
+	return header.Value
+}
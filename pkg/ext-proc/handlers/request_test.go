@@ -0,0 +1,598 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	configPb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/quota"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/scheduling"
+)
+
+func TestHandleRequestHeaders(t *testing.T) {
+	tests := []struct {
+		name            string
+		sessionHeader   string
+		headers         []*configPb.HeaderValue
+		wantCriticality v1alpha1.Criticality
+		wantSessionID   string
+	}{
+		{
+			name: "no criticality header",
+			headers: []*configPb.HeaderValue{
+				{Key: "content-type", RawValue: []byte("application/json")},
+			},
+		},
+		{
+			name: "criticality header in raw value",
+			headers: []*configPb.HeaderValue{
+				{Key: CriticalityHeader, RawValue: []byte("Sheddable")},
+			},
+			wantCriticality: v1alpha1.Sheddable,
+		},
+		{
+			name: "criticality header in value",
+			headers: []*configPb.HeaderValue{
+				{Key: "X-LLM-Criticality", Value: "Critical"},
+			},
+			wantCriticality: v1alpha1.Critical,
+		},
+		{
+			name: "session header disabled",
+			headers: []*configPb.HeaderValue{
+				{Key: "x-session-id", RawValue: []byte("session-1")},
+			},
+		},
+		{
+			name:          "session header",
+			sessionHeader: "x-session-id",
+			headers: []*configPb.HeaderValue{
+				{Key: "x-session-id", RawValue: []byte("session-1")},
+			},
+			wantSessionID: "session-1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := NewServer(nil, nil, "target-pod", nil, WithSessionHeader(test.sessionHeader))
+			reqCtx := &RequestContext{}
+			req := &extProcPb.ProcessingRequest{
+				Request: &extProcPb.ProcessingRequest_RequestHeaders{
+					RequestHeaders: &extProcPb.HttpHeaders{
+						Headers: &configPb.HeaderMap{Headers: test.headers},
+					},
+				},
+			}
+			server.HandleRequestHeaders(reqCtx, req)
+			if reqCtx.RequestedCriticality != test.wantCriticality {
+				t.Errorf("Unexpected requested criticality, got %q, want %q", reqCtx.RequestedCriticality, test.wantCriticality)
+			}
+			if reqCtx.SessionID != test.wantSessionID {
+				t.Errorf("Unexpected session ID, got %q, want %q", reqCtx.SessionID, test.wantSessionID)
+			}
+		})
+	}
+}
+
+type fakeScheduler struct {
+	pod backend.Pod
+	req *scheduling.LLMRequest
+	// exhausted are the target models without capacity.
+	exhausted map[string]bool
+}
+
+func (f *fakeScheduler) Schedule(req *scheduling.LLMRequest) (backend.Pod, error) {
+	f.req = req
+	req.Decision = &scheduling.Decision{Model: req.Model, TargetModel: req.ResolvedTargetModel}
+	if f.exhausted[req.ResolvedTargetModel] {
+		return backend.Pod{}, status.Errorf(codes.ResourceExhausted, "no capacity for %v", req.ResolvedTargetModel)
+	}
+	return f.pod, nil
+}
+
+type fakeDemandRecorder struct {
+	targetModels []string
+}
+
+func (r *fakeDemandRecorder) RecordRequest(targetModel string) {
+	r.targetModels = append(r.targetModels, targetModel)
+}
+
+func TestHandleRequestBodyUnknownModel(t *testing.T) {
+	models := map[string]*v1alpha1.InferenceModel{
+		"fallback": {
+			Spec: v1alpha1.InferenceModelSpec{
+				ModelName: "fallback",
+				TargetModels: []v1alpha1.TargetModel{
+					{Name: "fallback-v1", Weight: 100},
+				},
+			},
+		},
+	}
+	tests := []struct {
+		name          string
+		policy        UnknownModelPolicy
+		fallbackModel string
+		wantCode      codes.Code
+		wantTarget    string
+		wantBodyModel string
+		// wantDemand is the target models whose demand is recorded.
+		wantDemand []string
+	}{
+		{
+			name:     "reject by default",
+			wantCode: codes.NotFound,
+		},
+		{
+			name:     "reject",
+			policy:   RejectUnknownModels,
+			wantCode: codes.NotFound,
+		},
+		{
+			name:          "passthrough",
+			policy:        PassthroughUnknownModels,
+			wantTarget:    "unknown",
+			wantBodyModel: "unknown",
+		},
+		{
+			name:          "fallback to an InferenceModel",
+			policy:        FallbackUnknownModels,
+			fallbackModel: "fallback",
+			wantTarget:    "fallback-v1",
+			wantBodyModel: "fallback-v1",
+			wantDemand:    []string{"fallback-v1"},
+		},
+		{
+			name:          "fallback to a model without InferenceModel",
+			policy:        FallbackUnknownModels,
+			fallbackModel: "base",
+			wantTarget:    "base",
+			wantBodyModel: "base",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheduler := &fakeScheduler{pod: backend.Pod{Name: "pod", Address: "address"}}
+			demand := &fakeDemandRecorder{}
+			server := NewServer(nil, scheduler, "target-pod", &backend.FakeDataStore{Res: models},
+				WithUnknownModelPolicy(test.policy, test.fallbackModel), WithModelDemandRecorder(demand))
+			req := &extProcPb.ProcessingRequest{
+				Request: &extProcPb.ProcessingRequest_RequestBody{
+					RequestBody: &extProcPb.HttpBody{Body: []byte(`{"model":"unknown","prompt":"hello"}`), EndOfStream: true},
+				},
+			}
+			resp, err := server.HandleRequestBody(&RequestContext{}, req)
+			if status.Code(err) != test.wantCode {
+				t.Fatalf("Unexpected error code, got %v, want %v", err, test.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(test.wantDemand, demand.targetModels); diff != "" {
+				t.Errorf("Unexpected recorded demand (-want +got): %v", diff)
+			}
+			if scheduler.req.ResolvedTargetModel != test.wantTarget {
+				t.Errorf("Unexpected target model, got %v, want %v", scheduler.req.ResolvedTargetModel, test.wantTarget)
+			}
+			if scheduler.req.Criticality != v1alpha1.Default {
+				t.Errorf("Unexpected criticality, got %v, want %v", scheduler.req.Criticality, v1alpha1.Default)
+			}
+			var body map[string]any
+			if err := json.Unmarshal(resp.GetRequestBody().GetResponse().GetBodyMutation().GetBody(), &body); err != nil {
+				t.Fatalf("Failed to unmarshal body: %v", err)
+			}
+			if body["model"] != test.wantBodyModel {
+				t.Errorf("Unexpected model in body, got %v, want %v", body["model"], test.wantBodyModel)
+			}
+		})
+	}
+}
+
+func TestHandleRequestBodyConsistentTrafficSplit(t *testing.T) {
+	model := &v1alpha1.InferenceModel{
+		Spec: v1alpha1.InferenceModelSpec{
+			ModelName: "my-model",
+			TargetModels: []v1alpha1.TargetModel{
+				{Name: "v1", Weight: 50},
+				{Name: "v2", Weight: 50},
+			},
+		},
+	}
+	// The keys hash to the target models: user-1 and session-1 to v2, user-4 and session-2 to v1.
+	tests := []struct {
+		name   string
+		reqCtx RequestContext
+		want   string
+	}{
+		{
+			name:   "traffic split header to v1",
+			reqCtx: RequestContext{TrafficSplitKey: "user-4"},
+			want:   "v1",
+		},
+		{
+			name:   "traffic split header to v2",
+			reqCtx: RequestContext{TrafficSplitKey: "user-1"},
+			want:   "v2",
+		},
+		{
+			name:   "session to v1",
+			reqCtx: RequestContext{SessionID: "session-2"},
+			want:   "v1",
+		},
+		{
+			name:   "session to v2",
+			reqCtx: RequestContext{SessionID: "session-1"},
+			want:   "v2",
+		},
+		{
+			name:   "traffic split header to v2 wins over the session to v1",
+			reqCtx: RequestContext{TrafficSplitKey: "user-1", SessionID: "session-2"},
+			want:   "v2",
+		},
+		{
+			name:   "traffic split header to v1 wins over the session to v2",
+			reqCtx: RequestContext{TrafficSplitKey: "user-4", SessionID: "session-1"},
+			want:   "v1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheduler := &fakeScheduler{pod: backend.Pod{Name: "pod", Address: "address"}}
+			server := NewServer(nil, scheduler, "target-pod", &backend.FakeDataStore{Res: map[string]*v1alpha1.InferenceModel{"my-model": model}}, WithConsistentTrafficSplit("x-user-id"))
+			req := &extProcPb.ProcessingRequest{
+				Request: &extProcPb.ProcessingRequest_RequestBody{
+					RequestBody: &extProcPb.HttpBody{Body: []byte(`{"model":"my-model","prompt":"hello"}`), EndOfStream: true},
+				},
+			}
+			for range 10 {
+				reqCtx := test.reqCtx
+				if _, err := server.HandleRequestBody(&reqCtx, req); err != nil {
+					t.Fatalf("HandleRequestBody returned unexpected error: %v", err)
+				}
+				if scheduler.req.ResolvedTargetModel != test.want {
+					t.Fatalf("Unexpected target model, got %v, want %v", scheduler.req.ResolvedTargetModel, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleRequestBodyRules(t *testing.T) {
+	model := &v1alpha1.InferenceModel{
+		Spec: v1alpha1.InferenceModelSpec{
+			ModelName: "my-model",
+			TargetModels: []v1alpha1.TargetModel{
+				{Name: "v1", Weight: 100},
+			},
+			Rules: []v1alpha1.TargetModelRule{
+				{
+					Matches:     []v1alpha1.RequestMatch{{Type: v1alpha1.HeaderMatch, Name: "x-beta-user", Value: "true"}},
+					TargetModel: "beta",
+				},
+				{
+					Matches:     []v1alpha1.RequestMatch{{Type: v1alpha1.BodyFieldMatch, Name: "max_tokens", Operator: v1alpha1.MatchGreaterThan, Value: "4096"}},
+					TargetModel: "long-context",
+				},
+			},
+		},
+	}
+	tests := []struct {
+		name    string
+		headers []*configPb.HeaderValue
+		body    string
+		want    string
+	}{
+		{
+			name: "no rule matches",
+			body: `{"model":"my-model","max_tokens":100}`,
+			want: "v1",
+		},
+		{
+			name:    "header rule",
+			headers: []*configPb.HeaderValue{{Key: "X-Beta-User", RawValue: []byte("true")}},
+			body:    `{"model":"my-model"}`,
+			want:    "beta",
+		},
+		{
+			name: "body field rule",
+			body: `{"model":"my-model","max_tokens":8192}`,
+			want: "long-context",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheduler := &fakeScheduler{pod: backend.Pod{Name: "pod", Address: "address"}}
+			server := NewServer(nil, scheduler, "target-pod", &backend.FakeDataStore{Res: map[string]*v1alpha1.InferenceModel{"my-model": model}})
+			reqCtx := &RequestContext{}
+			server.HandleRequestHeaders(reqCtx, &extProcPb.ProcessingRequest{
+				Request: &extProcPb.ProcessingRequest_RequestHeaders{
+					RequestHeaders: &extProcPb.HttpHeaders{Headers: &configPb.HeaderMap{Headers: test.headers}},
+				},
+			})
+			req := &extProcPb.ProcessingRequest{
+				Request: &extProcPb.ProcessingRequest_RequestBody{
+					RequestBody: &extProcPb.HttpBody{Body: []byte(test.body), EndOfStream: true},
+				},
+			}
+			if _, err := server.HandleRequestBody(reqCtx, req); err != nil {
+				t.Fatalf("HandleRequestBody returned unexpected error: %v", err)
+			}
+			if scheduler.req.ResolvedTargetModel != test.want {
+				t.Errorf("Unexpected target model, got %v, want %v", scheduler.req.ResolvedTargetModel, test.want)
+			}
+		})
+	}
+}
+
+func TestHandleRequestBodyFallbacks(t *testing.T) {
+	model := &v1alpha1.InferenceModel{
+		Spec: v1alpha1.InferenceModelSpec{
+			ModelName:    "my-model",
+			TargetModels: []v1alpha1.TargetModel{{Name: "v1", Weight: 100}},
+			Fallbacks:    []string{"small", "base"},
+		},
+	}
+	tests := []struct {
+		name      string
+		exhausted map[string]bool
+		wantCode  codes.Code
+		want      string
+	}{
+		{
+			name: "capacity for the target model",
+			want: "v1",
+		},
+		{
+			name:      "first fallback",
+			exhausted: map[string]bool{"v1": true},
+			want:      "small",
+		},
+		{
+			name:      "second fallback",
+			exhausted: map[string]bool{"v1": true, "small": true},
+			want:      "base",
+		},
+		{
+			name:      "no capacity",
+			exhausted: map[string]bool{"v1": true, "small": true, "base": true},
+			wantCode:  codes.ResourceExhausted,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheduler := &fakeScheduler{pod: backend.Pod{Name: "pod", Address: "address"}, exhausted: test.exhausted}
+			server := NewServer(nil, scheduler, "target-pod", &backend.FakeDataStore{Res: map[string]*v1alpha1.InferenceModel{"my-model": model}})
+			req := &extProcPb.ProcessingRequest{
+				Request: &extProcPb.ProcessingRequest_RequestBody{
+					RequestBody: &extProcPb.HttpBody{Body: []byte(`{"model":"my-model","prompt":"hello"}`), EndOfStream: true},
+				},
+			}
+			reqCtx := &RequestContext{}
+			resp, err := server.HandleRequestBody(reqCtx, req)
+			if status.Code(err) != test.wantCode {
+				t.Fatalf("Unexpected error code, got %v, want %v", err, test.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			var body map[string]any
+			if err := json.Unmarshal(resp.GetRequestBody().GetResponse().GetBodyMutation().GetBody(), &body); err != nil {
+				t.Fatalf("Failed to unmarshal body: %v", err)
+			}
+			if body["model"] != test.want || reqCtx.ResolvedTargetModel != test.want {
+				t.Errorf("Unexpected served model, got %v in body and %v in context, want %v", body["model"], reqCtx.ResolvedTargetModel, test.want)
+			}
+		})
+	}
+}
+
+type fakePodMetricsProvider []*backend.PodMetrics
+
+func (f fakePodMetricsProvider) AllPodMetrics() []*backend.PodMetrics {
+	return f
+}
+
+func TestHandleRequestBodyFallbacksScheduler(t *testing.T) {
+	model := &v1alpha1.InferenceModel{
+		Spec: v1alpha1.InferenceModelSpec{
+			ModelName:    "my-model",
+			TargetModels: []v1alpha1.TargetModel{{Name: "v1", Weight: 100}},
+			Fallbacks:    []string{"small", "base"},
+		},
+	}
+	// pod returns a pod whose two adapter slots are full with "other" and the active model.
+	pod := func(activeModel string) *backend.PodMetrics {
+		pm := &backend.PodMetrics{
+			Pod:     backend.Pod{Name: "pod", Address: "address"},
+			Metrics: backend.Metrics{MaxActiveModels: 2, ActiveModels: map[string]int{"other": 1}},
+		}
+		pm.ActiveModels[activeModel] = 1
+		return pm
+	}
+	tests := []struct {
+		name     string
+		pod      *backend.PodMetrics
+		wantCode codes.Code
+		want     string
+	}{
+		{
+			name: "target model loaded",
+			pod:  pod("v1"),
+			want: "v1",
+		},
+		{
+			name: "fallback adapter loaded",
+			pod:  pod("small"),
+			want: "small",
+		},
+		{
+			name: "fallback base model",
+			pod:  pod("unrelated"),
+			want: "base",
+		},
+		{
+			name: "overloaded",
+			pod: &backend.PodMetrics{
+				Pod:     backend.Pod{Name: "pod", Address: "address"},
+				Metrics: backend.Metrics{WaitingQueueSize: 100, ActiveModels: map[string]int{}},
+			},
+			wantCode: codes.ResourceExhausted,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheduler := scheduling.NewScheduler(fakePodMetricsProvider{test.pod}, scheduling.WithBaseModels([]string{"base"}))
+			server := NewServer(nil, scheduler, "target-pod", &backend.FakeDataStore{Res: map[string]*v1alpha1.InferenceModel{"my-model": model}})
+			req := &extProcPb.ProcessingRequest{
+				Request: &extProcPb.ProcessingRequest_RequestBody{
+					RequestBody: &extProcPb.HttpBody{Body: []byte(`{"model":"my-model","prompt":"hello"}`), EndOfStream: true},
+				},
+			}
+			reqCtx := &RequestContext{}
+			_, err := server.HandleRequestBody(reqCtx, req)
+			if status.Code(err) != test.wantCode {
+				t.Fatalf("Unexpected error code, got %v, want %v", err, test.wantCode)
+			}
+			if err == nil && reqCtx.ResolvedTargetModel != test.want {
+				t.Errorf("Unexpected served model, got %v, want %v", reqCtx.ResolvedTargetModel, test.want)
+			}
+		})
+	}
+}
+
+func TestHandleRequestBodyQuota(t *testing.T) {
+	model := &v1alpha1.InferenceModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-model", Namespace: "default"},
+		Spec: v1alpha1.InferenceModelSpec{
+			ModelName: "my-model",
+			Quota:     &v1alpha1.TokenQuota{Tokens: 30, Period: v1alpha1.QuotaPeriodHour, PerTenant: true},
+		},
+	}
+	scheduler := &fakeScheduler{pod: backend.Pod{Name: "pod", Address: "address"}}
+	server := NewServer(nil, scheduler, "target-pod", &backend.FakeDataStore{Res: map[string]*v1alpha1.InferenceModel{"my-model": model}},
+		WithQuotaLimiter(quota.NewLimiter(quota.NewMemoryStore())), WithTenantHeader("X-Tenant"))
+	send := func(tenant string) (*RequestContext, error) {
+		req := &extProcPb.ProcessingRequest{
+			Request: &extProcPb.ProcessingRequest_RequestBody{
+				// The request is estimated to use 32 tokens, which exhausts the quota.
+				RequestBody: &extProcPb.HttpBody{Body: []byte(`{"model":"my-model","prompt":"hello","max_tokens":20}`), EndOfStream: true},
+			},
+		}
+		reqCtx := &RequestContext{Headers: map[string]string{"x-tenant": tenant}}
+		_, err := server.HandleRequestBody(reqCtx, req)
+		return reqCtx, err
+	}
+
+	first, err := send("tenant-a")
+	if err != nil {
+		t.Fatalf("Unexpected error for the first request: %v", err)
+	}
+	_, err = send("tenant-a")
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Unexpected error for a request with an exhausted quota, got %v, want %v", err, codes.ResourceExhausted)
+	}
+	wantBody := `{"error":{"message":"The token quota of the model is exhausted, please retry later.","type":"server_overloaded","code":"rate_limit_exceeded"}}`
+	if body := string(errorResponse(err).GetImmediateResponse().GetBody()); body != wantBody {
+		t.Errorf("Unexpected error body, got %s, want %s", body, wantBody)
+	}
+	if _, err := send("tenant-b"); err != nil {
+		t.Errorf("Unexpected error for another tenant: %v", err)
+	}
+
+	// The tokens actually used by the first request are less than the quota.
+	first.quotaReservation.Commit(context.Background(), 10)
+	if _, err := send("tenant-a"); err != nil {
+		t.Errorf("Unexpected error after the usage of the first request was committed: %v", err)
+	}
+}
+
+func TestHandleRequestBodyChunks(t *testing.T) {
+	model := &v1alpha1.InferenceModel{
+		Spec: v1alpha1.InferenceModelSpec{
+			ModelName:    "my-model",
+			TargetModels: []v1alpha1.TargetModel{{Name: "my-model-v1", Weight: 100}},
+		},
+	}
+	type chunk struct {
+		body        string
+		endOfStream bool
+	}
+	tests := []struct {
+		name     string
+		chunks   []chunk
+		wantBody string
+		// wantErrChunk is the index of the chunk rejected, if any.
+		wantErrChunk int
+	}{
+		{
+			name:         "buffered body",
+			chunks:       []chunk{{body: `{"model":"my-model","prompt":"hello"}`, endOfStream: true}},
+			wantBody:     `{"model":"my-model-v1","prompt":"hello"}`,
+			wantErrChunk: -1,
+		},
+		{
+			name:         "buffered body followed by trailers",
+			chunks:       []chunk{{body: `{"model":"my-model","prompt":"hello"}`}},
+			wantBody:     `{"model":"my-model-v1","prompt":"hello"}`,
+			wantErrChunk: -1,
+		},
+		{
+			name:         "partially buffered body",
+			chunks:       []chunk{{body: `{"prompt":"hel`}, {body: `lo","model":"my-model"}`, endOfStream: true}},
+			wantErrChunk: 0,
+		},
+		{
+			name:         "streamed body",
+			chunks:       []chunk{{body: `{"model":"my-model","prompt":"hello"}`}, {body: ` `, endOfStream: true}},
+			wantBody:     `{"model":"my-model-v1","prompt":"hello"}`,
+			wantErrChunk: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheduler := &fakeScheduler{pod: backend.Pod{Name: "pod", Address: "address"}}
+			server := NewServer(nil, scheduler, "target-pod", &backend.FakeDataStore{Res: map[string]*v1alpha1.InferenceModel{"my-model": model}})
+			reqCtx := &RequestContext{}
+			for i, c := range test.chunks {
+				req := &extProcPb.ProcessingRequest{
+					Request: &extProcPb.ProcessingRequest_RequestBody{
+						RequestBody: &extProcPb.HttpBody{Body: []byte(c.body), EndOfStream: c.endOfStream},
+					},
+				}
+				resp, err := server.HandleRequestBody(reqCtx, req)
+				if i == test.wantErrChunk {
+					if status.Code(err) != codes.FailedPrecondition {
+						t.Fatalf("Unexpected error for chunk %d, got %v, want %v", i, err, codes.FailedPrecondition)
+					}
+					wantBody := `{"error":{"message":"The gateway is not configured to route request bodies sent in chunks.","type":"server_error","code":"unsupported_body_mode"}}`
+					if body := string(errorResponse(err).GetImmediateResponse().GetBody()); body != wantBody {
+						t.Errorf("Unexpected error body, got %s, want %s", body, wantBody)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Unexpected error for chunk %d: %v", i, err)
+				}
+				if got := string(resp.GetRequestBody().GetResponse().GetBodyMutation().GetBody()); got != test.wantBody {
+					t.Errorf("Unexpected body, got %s, want %s", got, test.wantBody)
+				}
+				if reqCtx.TargetPod.Name != "pod" {
+					t.Errorf("Unexpected target pod %v", reqCtx.TargetPod)
+				}
+			}
+			if test.wantErrChunk >= 0 {
+				t.Errorf("Expected chunk %d to be rejected", test.wantErrChunk)
+			}
+		})
+	}
+}
@@ -2,33 +2,52 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	configPb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	klog "k8s.io/klog/v2"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
 )
 
 // HandleResponseHeaders processes response headers from the backend model server.
 func (s *Server) HandleResponseHeaders(reqCtx *RequestContext, req *extProcPb.ProcessingRequest) (*extProcPb.ProcessingResponse, error) {
 	klog.V(3).Info("Processing ResponseHeaders")
+	span := s.startSpan(reqCtx, "process_response_headers")
+	defer span.End()
 	h := req.Request.(*extProcPb.ProcessingRequest_ResponseHeaders)
 	klog.V(3).Infof("Headers before: %+v\n", h)
+	s.recordResponse(reqCtx, h.ResponseHeaders.GetHeaders().GetHeaders())
+	s.applyLoadReport(reqCtx, h.ResponseHeaders.GetHeaders().GetHeaders())
+
+	headers := []*configPb.HeaderValueOption{
+		{
+			Header: &configPb.HeaderValue{
+				// This is for debugging purpose only.
+				Key:      "x-went-into-resp-headers",
+				RawValue: []byte("true"),
+			},
+		},
+		{
+			Header: &configPb.HeaderValue{
+				Key:      ServedModelHeader,
+				RawValue: []byte(reqCtx.ResolvedTargetModel),
+			},
+		},
+	}
+	headers = append(headers, s.debugResponseHeaders(reqCtx)...)
 
 	resp := &extProcPb.ProcessingResponse{
 		Response: &extProcPb.ProcessingResponse_ResponseHeaders{
 			ResponseHeaders: &extProcPb.HeadersResponse{
 				Response: &extProcPb.CommonResponse{
 					HeaderMutation: &extProcPb.HeaderMutation{
-						SetHeaders: []*configPb.HeaderValueOption{
-							{
-								Header: &configPb.HeaderValue{
-									// This is for debugging purpose only.
-									Key:      "x-went-into-resp-headers",
-									RawValue: []byte("true"),
-								},
-							},
-						},
+						SetHeaders: headers,
 					},
 				},
 			},
@@ -37,6 +56,94 @@ func (s *Server) HandleResponseHeaders(reqCtx *RequestContext, req *extProcPb.Pr
 	return resp, nil
 }
 
+// HandleResponseTrailers processes response trailers from the backend model server, which may
+// carry the load report of the model server, e.g. for streamed responses.
+func (s *Server) HandleResponseTrailers(reqCtx *RequestContext, req *extProcPb.ProcessingRequest) (*extProcPb.ProcessingResponse, error) {
+	klog.V(3).Info("Processing ResponseTrailers")
+	t := req.Request.(*extProcPb.ProcessingRequest_ResponseTrailers)
+	s.applyLoadReport(reqCtx, t.ResponseTrailers.GetTrailers().GetHeaders())
+	return &extProcPb.ProcessingResponse{
+		Response: &extProcPb.ProcessingResponse_ResponseTrailers{
+			ResponseTrailers: &extProcPb.TrailersResponse{},
+		},
+	}, nil
+}
+
+// applyLoadReport updates the metrics of the pod which served the request from the ORCA load
+// report in the headers, if any. Load reports are fresher than the metrics polled from the pod.
+func (s *Server) applyLoadReport(reqCtx *RequestContext, headers []*configPb.HeaderValue) {
+	if s.podProvider == nil || reqCtx.TargetPod.Name == "" {
+		return
+	}
+	for _, header := range headers {
+		key := strings.ToLower(header.Key)
+		if key != backend.ORCAHeader && key != backend.ORCABinaryHeader {
+			continue
+		}
+		report, err := backend.ParseORCAHeader(headerValue(header), key == backend.ORCABinaryHeader)
+		if err != nil {
+			klog.Errorf("Invalid load report of pod %v: %v", reqCtx.TargetPod, err)
+			return
+		}
+		klog.V(4).Infof("Load report of pod %v: %v", reqCtx.TargetPod, report)
+		s.podProvider.ApplyLoadReport(reqCtx.TargetPod, report)
+		return
+	}
+}
+
+// debugResponseHeaders returns the enabled debug headers exposing the routing decisions made for
+// the request.
+func (s *Server) debugResponseHeaders(reqCtx *RequestContext) []*configPb.HeaderValueOption {
+	var headers []*configPb.HeaderValueOption
+	for _, h := range s.debugHeaders {
+		var value string
+		switch h {
+		case DebugHeaderPod:
+			value = reqCtx.TargetPod.Name
+		case DebugHeaderTargetModel:
+			value = reqCtx.ResolvedTargetModel
+		case DebugHeaderCriticality:
+			value = string(reqCtx.Criticality)
+		case DebugHeaderSchedulingPath:
+			value = strings.Join(reqCtx.SchedulingPath, " > ")
+		case DebugHeaderQueueWait:
+			value = strconv.FormatInt(reqCtx.QueueWait.Milliseconds(), 10)
+		default:
+			continue
+		}
+		headers = append(headers, &configPb.HeaderValueOption{
+			Header: &configPb.HeaderValue{
+				Key:      h.Key(),
+				RawValue: []byte(value),
+			},
+		})
+	}
+	return headers
+}
+
+// recordResponse records the status code and latency of the response in the request context, and
+// notifies the response recorder, if any.
+func (s *Server) recordResponse(reqCtx *RequestContext, headers []*configPb.HeaderValue) {
+	reqCtx.ResponseLatency = time.Since(reqCtx.RequestReceivedTimestamp)
+	for _, header := range headers {
+		if header.Key != ":status" {
+			continue
+		}
+		statusCode, err := strconv.Atoi(headerValue(header))
+		if err != nil {
+			klog.Errorf("Invalid response status %q: %v", headerValue(header), err)
+			return
+		}
+		reqCtx.StatusCode = statusCode
+		reqCtx.served = statusCode < 400
+		break
+	}
+	if s.responseRecorder == nil || reqCtx.ResolvedTargetModel == "" || reqCtx.StatusCode == 0 {
+		return
+	}
+	s.responseRecorder.RecordResponse(reqCtx.ModelName, reqCtx.ResolvedTargetModel, reqCtx.StatusCode, reqCtx.ResponseLatency)
+}
+
 // HandleResponseBody parses response body to update information such as number of completion tokens.
 // Example response
 /*
@@ -63,14 +170,25 @@ func (s *Server) HandleResponseHeaders(reqCtx *RequestContext, req *extProcPb.Pr
 }*/
 func (s *Server) HandleResponseBody(reqCtx *RequestContext, req *extProcPb.ProcessingRequest) (*extProcPb.ProcessingResponse, error) {
 	klog.V(3).Info("Processing HandleResponseBody")
+	span := s.startSpan(reqCtx, "process_response_body")
 	body := req.Request.(*extProcPb.ProcessingRequest_ResponseBody)
 
 	res := Response{}
 	if err := json.Unmarshal(body.ResponseBody.Body, &res); err != nil {
-		return nil, fmt.Errorf("unmarshaling response body: %v", err)
+		err = status.Errorf(codes.Internal, "unmarshaling response body: %v", err)
+		endSpan(span, err)
+		return nil, err
 	}
+	endSpan(span, nil)
 	reqCtx.Response = res
 	klog.V(3).Infof("Response: %+v", res)
+	s.recordUsage(reqCtx)
+	s.observeLatency(reqCtx)
+	if tokens := res.Usage.PromptTokens + res.Usage.CompletionTokens; tokens > 0 {
+		reqCtx.quotaReservation.Commit(reqCtx.context(), int64(tokens))
+	}
+	// Otherwise the response does not report its usage, e.g. if it is streamed, and the estimate
+	// stays reserved, so that omitting the usage does not bypass the quota.
 
 	resp := &extProcPb.ProcessingResponse{
 		Response: &extProcPb.ProcessingResponse_ResponseBody{
@@ -82,6 +200,29 @@ func (s *Server) HandleResponseBody(reqCtx *RequestContext, req *extProcPb.Proce
 	return resp, nil
 }
 
+// recordUsage notifies the usage recorder, if any, of the tokens used by the request.
+func (s *Server) recordUsage(reqCtx *RequestContext) {
+	if s.usageRecorder == nil || reqCtx.ResolvedTargetModel == "" {
+		return
+	}
+	usage := reqCtx.Response.Usage
+	s.usageRecorder.RecordUsage(reqCtx.ModelName, reqCtx.ResolvedTargetModel, s.tenant(reqCtx), usage.PromptTokens, usage.CompletionTokens)
+}
+
+// observeLatency notifies the latency observer, if any, of the latency of the request since it was
+// scheduled, if the scheduler predicted it. Only the successful responses reporting their usage are
+// observed, since the latency of errors and of unknown numbers of tokens would skew the model.
+func (s *Server) observeLatency(reqCtx *RequestContext) {
+	if s.latencyObserver == nil || reqCtx.latencyPrediction == nil || reqCtx.RequestReceivedTimestamp.IsZero() {
+		return
+	}
+	if reqCtx.StatusCode < 200 || reqCtx.StatusCode >= 300 || reqCtx.Response.Usage.CompletionTokens == 0 {
+		return
+	}
+	latency := time.Since(reqCtx.RequestReceivedTimestamp) - reqCtx.QueueWait
+	s.latencyObserver.ObserveLatency(reqCtx.latencyPrediction.Features, latency, reqCtx.Response.Usage.CompletionTokens)
+}
+
 type Response struct {
 	Usage Usage `json:"usage"`
 }
@@ -1,10 +1,24 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
 	"testing"
+	"time"
 
+	orcav3 "github.com/cncf/xds/go/xds/data/orca/v3"
+	configPb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
 	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/quota"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/scheduling"
 )
 
 const (
@@ -85,3 +99,349 @@ func TestHandleResponseBody(t *testing.T) {
 		})
 	}
 }
+
+type recordedResponse struct {
+	model       string
+	targetModel string
+	statusCode  int
+}
+
+type fakeResponseRecorder struct {
+	responses []recordedResponse
+}
+
+func (r *fakeResponseRecorder) RecordResponse(model, targetModel string, statusCode int, latency time.Duration) {
+	r.responses = append(r.responses, recordedResponse{model: model, targetModel: targetModel, statusCode: statusCode})
+}
+
+func TestHandleResponseHeadersRecordsResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		reqCtx *RequestContext
+		status string
+		want   []recordedResponse
+	}{
+		{
+			name:   "success",
+			reqCtx: &RequestContext{Model: "my-model", ModelName: "my-model", ResolvedTargetModel: "v2"},
+			status: "200",
+			want:   []recordedResponse{{model: "my-model", targetModel: "v2", statusCode: 200}},
+		},
+		{
+			name:   "failure",
+			reqCtx: &RequestContext{Model: "unknown", ModelName: "fallback", ResolvedTargetModel: "v1"},
+			status: "503",
+			want:   []recordedResponse{{model: "fallback", targetModel: "v1", statusCode: 503}},
+		},
+		{
+			name:   "invalid status",
+			reqCtx: &RequestContext{ModelName: "my-model", ResolvedTargetModel: "v2"},
+			status: "ok",
+		},
+		{
+			name:   "request not scheduled",
+			reqCtx: &RequestContext{},
+			status: "200",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := &fakeResponseRecorder{}
+			server := NewServer(nil, nil, "target-pod", nil, WithResponseRecorder(recorder))
+			req := &extProcPb.ProcessingRequest{
+				Request: &extProcPb.ProcessingRequest_ResponseHeaders{
+					ResponseHeaders: &extProcPb.HttpHeaders{
+						Headers: &configPb.HeaderMap{
+							Headers: []*configPb.HeaderValue{
+								{Key: ":status", RawValue: []byte(test.status)},
+							},
+						},
+					},
+				},
+			}
+			if _, err := server.HandleResponseHeaders(test.reqCtx, req); err != nil {
+				t.Fatalf("HandleResponseHeaders returned unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(test.want, recorder.responses, cmp.AllowUnexported(recordedResponse{})); diff != "" {
+				t.Errorf("Unexpected recorded responses (-want +got): %v", diff)
+			}
+		})
+	}
+}
+
+func TestHandleResponseHeadersDebugHeaders(t *testing.T) {
+	reqCtx := &RequestContext{
+		TargetPod:           backend.Pod{Name: "pod1", Address: "10.0.0.1:8000"},
+		ResolvedTargetModel: "v2",
+		Criticality:         v1alpha1.Sheddable,
+		SchedulingPath:      []string{"critical request", "default request"},
+		QueueWait:           42 * time.Millisecond,
+	}
+	tests := []struct {
+		name         string
+		debugHeaders []DebugHeader
+		want         map[string]string
+	}{
+		{
+			name: "disabled",
+			want: map[string]string{},
+		},
+		{
+			name: "all",
+			debugHeaders: []DebugHeader{
+				DebugHeaderPod, DebugHeaderTargetModel, DebugHeaderCriticality, DebugHeaderSchedulingPath, DebugHeaderQueueWait,
+			},
+			want: map[string]string{
+				"x-gateway-pod":             "pod1",
+				"x-gateway-target-model":    "v2",
+				"x-gateway-criticality":     "Sheddable",
+				"x-gateway-scheduling-path": "critical request > default request",
+				"x-gateway-queue-wait-ms":   "42",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := NewServer(nil, nil, "target-pod", nil, WithDebugHeaders(test.debugHeaders...))
+			req := &extProcPb.ProcessingRequest{
+				Request: &extProcPb.ProcessingRequest_ResponseHeaders{
+					ResponseHeaders: &extProcPb.HttpHeaders{},
+				},
+			}
+			resp, err := server.HandleResponseHeaders(reqCtx, req)
+			if err != nil {
+				t.Fatalf("HandleResponseHeaders returned unexpected error: %v", err)
+			}
+			got := make(map[string]string)
+			for _, h := range resp.GetResponseHeaders().GetResponse().GetHeaderMutation().GetSetHeaders() {
+				if h.Header.Key != "x-went-into-resp-headers" && h.Header.Key != ServedModelHeader {
+					got[h.Header.Key] = string(h.Header.RawValue)
+				}
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Unexpected debug headers (-want +got): %v", diff)
+			}
+		})
+	}
+}
+
+type fakeUsageRecorder struct {
+	usage []string
+}
+
+func (r *fakeUsageRecorder) RecordUsage(model, targetModel, tenant string, promptTokens, completionTokens int) {
+	r.usage = append(r.usage, fmt.Sprintf("%s/%s/%s: %d/%d", model, targetModel, tenant, promptTokens, completionTokens))
+}
+
+func TestHandleResponseBodyRecordsUsage(t *testing.T) {
+	recorder := &fakeUsageRecorder{}
+	server := &Server{usageRecorder: recorder, tenantHeader: "X-Tenant"}
+	reqCtx := &RequestContext{
+		ModelName:           "my-model",
+		ResolvedTargetModel: "my-model-v1",
+		Headers:             map[string]string{"x-tenant": "tenant-a"},
+	}
+	req := &extProcPb.ProcessingRequest{
+		Request: &extProcPb.ProcessingRequest_ResponseBody{
+			ResponseBody: &extProcPb.HttpBody{Body: []byte(body)},
+		},
+	}
+	if _, err := server.HandleResponseBody(reqCtx, req); err != nil {
+		t.Fatalf("HandleResponseBody: %v", err)
+	}
+	want := []string{"my-model/my-model-v1/tenant-a: 11/100"}
+	if diff := cmp.Diff(want, recorder.usage); diff != "" {
+		t.Errorf("Unexpected usage (-want +got): %v", diff)
+	}
+}
+
+type fakePodProvider struct {
+	PodProvider
+	reports map[backend.Pod]*orcav3.OrcaLoadReport
+}
+
+func (p *fakePodProvider) ApplyLoadReport(pod backend.Pod, report *orcav3.OrcaLoadReport) {
+	p.reports[pod] = report
+}
+
+func TestHandleResponseLoadReport(t *testing.T) {
+	pod := backend.Pod{Name: "pod1", Address: "10.0.0.1"}
+	tests := []struct {
+		name string
+		req  *extProcPb.ProcessingRequest
+		want map[backend.Pod]*orcav3.OrcaLoadReport
+	}{
+		{
+			name: "text header",
+			req: &extProcPb.ProcessingRequest{
+				Request: &extProcPb.ProcessingRequest_ResponseHeaders{
+					ResponseHeaders: &extProcPb.HttpHeaders{
+						Headers: &configPb.HeaderMap{Headers: []*configPb.HeaderValue{
+							{Key: ":status", RawValue: []byte("200")},
+							{Key: "endpoint-load-metrics", RawValue: []byte("TEXT named_metrics.waiting_queue_size=3")},
+						}},
+					},
+				},
+			},
+			want: map[backend.Pod]*orcav3.OrcaLoadReport{
+				pod: {NamedMetrics: map[string]float64{"waiting_queue_size": 3}},
+			},
+		},
+		{
+			name: "invalid header",
+			req: &extProcPb.ProcessingRequest{
+				Request: &extProcPb.ProcessingRequest_ResponseHeaders{
+					ResponseHeaders: &extProcPb.HttpHeaders{
+						Headers: &configPb.HeaderMap{Headers: []*configPb.HeaderValue{
+							{Key: "endpoint-load-metrics", RawValue: []byte("TEXT waiting_queue_size")},
+						}},
+					},
+				},
+			},
+			want: map[backend.Pod]*orcav3.OrcaLoadReport{},
+		},
+		{
+			name: "binary trailer",
+			req: &extProcPb.ProcessingRequest{
+				Request: &extProcPb.ProcessingRequest_ResponseTrailers{
+					ResponseTrailers: &extProcPb.HttpTrailers{
+						Trailers: &configPb.HeaderMap{Headers: []*configPb.HeaderValue{
+							// utilization {key: "kv_cache" value: 0.5}
+							{Key: "endpoint-load-metrics-bin", RawValue: []byte("KhMKCGt2X2NhY2hlEQAAAAAAAOA/")},
+						}},
+					},
+				},
+			},
+			want: map[backend.Pod]*orcav3.OrcaLoadReport{
+				pod: {Utilization: map[string]float64{"kv_cache": 0.5}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pp := &fakePodProvider{reports: make(map[backend.Pod]*orcav3.OrcaLoadReport)}
+			server := NewServer(pp, nil, "target-pod", nil)
+			reqCtx := &RequestContext{TargetPod: pod}
+			var err error
+			if _, ok := test.req.Request.(*extProcPb.ProcessingRequest_ResponseTrailers); ok {
+				_, err = server.HandleResponseTrailers(reqCtx, test.req)
+			} else {
+				_, err = server.HandleResponseHeaders(reqCtx, test.req)
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(test.want, pp.reports, protocmp.Transform()); diff != "" {
+				t.Errorf("Unexpected load reports (-want +got): %v", diff)
+			}
+		})
+	}
+}
+
+type fakeLatencyObserver struct {
+	features         scheduling.LatencyFeatures
+	latency          time.Duration
+	completionTokens int
+}
+
+func (o *fakeLatencyObserver) ObserveLatency(features scheduling.LatencyFeatures, latency time.Duration, completionTokens int) {
+	o.features, o.latency, o.completionTokens = features, latency, completionTokens
+}
+
+func TestHandleResponseBodyObservesLatency(t *testing.T) {
+	features := scheduling.LatencyFeatures{1, 2, 3}
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		// wantObserved is whether the latency is observed.
+		wantObserved bool
+	}{
+		{name: "successful response", statusCode: 200, body: body, wantObserved: true},
+		{name: "error response", statusCode: 503, body: body},
+		{name: "client error response", statusCode: 429, body: body},
+		{name: "response without usage", statusCode: 200, body: `{"object": "text_completion"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			observer := &fakeLatencyObserver{}
+			server := NewServer(nil, nil, "target-pod", nil, WithLatencyObserver(observer))
+			reqCtx := &RequestContext{
+				RequestReceivedTimestamp: time.Now().Add(-time.Second),
+				QueueWait:                200 * time.Millisecond,
+				StatusCode:               test.statusCode,
+				latencyPrediction:        &scheduling.LatencyPrediction{Features: features},
+			}
+			req := &extProcPb.ProcessingRequest{
+				Request: &extProcPb.ProcessingRequest_ResponseBody{
+					ResponseBody: &extProcPb.HttpBody{Body: []byte(test.body)},
+				},
+			}
+			if _, err := server.HandleResponseBody(reqCtx, req); err != nil {
+				t.Fatalf("HandleResponseBody: %v", err)
+			}
+			if !test.wantObserved {
+				if *observer != (fakeLatencyObserver{}) {
+					t.Errorf("Unexpected observation %+v", observer)
+				}
+				return
+			}
+			if observer.features != features || observer.completionTokens != 100 {
+				t.Errorf("Unexpected observation %+v", observer)
+			}
+			// The latency excludes the time the request waited in the gateway.
+			if observer.latency < 800*time.Millisecond || observer.latency > 900*time.Millisecond {
+				t.Errorf("Unexpected latency %v, want about 800ms", observer.latency)
+			}
+		})
+	}
+}
+
+func TestHandleResponseBodyQuota(t *testing.T) {
+	model := &v1alpha1.InferenceModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-model", Namespace: "default"},
+		Spec: v1alpha1.InferenceModelSpec{
+			ModelName: "my-model",
+			Quota:     &v1alpha1.TokenQuota{Tokens: 30, Period: v1alpha1.QuotaPeriodHour},
+		},
+	}
+	tests := []struct {
+		name string
+		body string
+		// wantExhausted is whether the quota is exhausted after the response.
+		wantExhausted bool
+	}{
+		{
+			name: "usage reported",
+			body: `{"usage":{"prompt_tokens":5,"completion_tokens":5,"total_tokens":10}}`,
+		},
+		{
+			name:          "usage missing",
+			body:          `{"choices":[]}`,
+			wantExhausted: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := quota.NewLimiter(quota.NewMemoryStore())
+			server := NewServer(nil, nil, "target-pod", nil, WithQuotaLimiter(limiter))
+			reservation, err := limiter.Reserve(context.Background(), model, "", 30)
+			if err != nil {
+				t.Fatalf("Reserve: %v", err)
+			}
+			reqCtx := &RequestContext{quotaReservation: reservation}
+			req := &extProcPb.ProcessingRequest{
+				Request: &extProcPb.ProcessingRequest_ResponseBody{
+					ResponseBody: &extProcPb.HttpBody{Body: []byte(test.body)},
+				},
+			}
+			if _, err := server.HandleResponseBody(reqCtx, req); err != nil {
+				t.Fatalf("HandleResponseBody: %v", err)
+			}
+			_, err = limiter.Reserve(context.Background(), model, "", 1)
+			if exhausted := status.Code(err) == codes.ResourceExhausted; exhausted != test.wantExhausted {
+				t.Errorf("Unexpected quota state, got exhausted %v, want %v", exhausted, test.wantExhausted)
+			}
+		})
+	}
+}
@@ -1,26 +1,38 @@
 package handlers
 
 import (
+	"context"
 	"io"
+	"time"
 
+	orcav3 "github.com/cncf/xds/go/xds/data/orca/v3"
 	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
-	envoyTypePb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	klog "k8s.io/klog/v2"
 
 	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
 	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/quota"
 	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/scheduling"
 )
 
-func NewServer(pp PodProvider, scheduler Scheduler, targetPodHeader string, datastore ModelDataStore) *Server {
-	return &Server{
+func NewServer(pp PodProvider, scheduler Scheduler, targetPodHeader string, datastore ModelDataStore, options ...ServerOption) *Server {
+	s := &Server{
 		scheduler:       scheduler,
 		podProvider:     pp,
 		targetPodHeader: targetPodHeader,
 		datastore:       datastore,
+		tracer:          noop.NewTracerProvider().Tracer(tracerName),
+		propagator:      propagation.TraceContext{},
 	}
+	for _, opt := range options {
+		opt(s)
+	}
+	return s
 }
 
 // Server implements the Envoy external processing server.
@@ -32,6 +44,201 @@ type Server struct {
 	// configuration.
 	targetPodHeader string
 	datastore       ModelDataStore
+	// The request header and the top-level request body field used to identify the session of a
+	// request for session affinity. The header takes precedence over the body field. Session
+	// affinity is disabled if both are empty.
+	sessionHeader    string
+	sessionBodyField string
+	// demandRecorder is notified of the target model of every request, if set.
+	demandRecorder ModelDemandRecorder
+	// unknownModelPolicy decides how requests for models without an InferenceModel are handled.
+	unknownModelPolicy UnknownModelPolicy
+	// fallbackModel is the model requests for unknown models are sent to with the
+	// FallbackUnknownModels policy.
+	fallbackModel string
+	// consistentTrafficSplit enables picking the target model of a request by hashing the value of
+	// the trafficSplitHeader, or the session ID, rather than at random.
+	consistentTrafficSplit bool
+	trafficSplitHeader     string
+	// responseRecorder is notified of the outcome of every response, if set.
+	responseRecorder ResponseRecorder
+	// debugHeaders are the optional response headers exposing routing decisions.
+	debugHeaders []DebugHeader
+	// maxRequestBodyBytes is the maximum size of the request bodies of all models. The limit is
+	// disabled if not positive.
+	maxRequestBodyBytes int64
+	// usageRecorder is notified of the tokens used by every request, if set.
+	usageRecorder UsageRecorder
+	// quotaLimiter rejects the requests for models whose token quota is exhausted, if set.
+	quotaLimiter *quota.Limiter
+	// tenantHeader is the request header identifying the tenant the tokens used by a request are
+	// accounted to.
+	tenantHeader string
+	// accessLogger records an access log entry for every request, if set.
+	accessLogger *AccessLogger
+	// latencyObserver is notified of the latency of the requests whose latency was predicted by
+	// the scheduler, if set.
+	latencyObserver LatencyObserver
+	// tracer creates the spans of the requests, and propagator extracts the trace context of the
+	// requests from their W3C trace context headers and propagates it to the model servers.
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// DebugHeader is an optional response header exposing a routing decision, for debugging.
+type DebugHeader string
+
+const (
+	// DebugHeaderPod reports the pod the request was sent to.
+	DebugHeaderPod DebugHeader = "pod"
+	// DebugHeaderTargetModel reports the target model the request was resolved to.
+	DebugHeaderTargetModel DebugHeader = "target-model"
+	// DebugHeaderCriticality reports the criticality the request was scheduled with.
+	DebugHeaderCriticality DebugHeader = "criticality"
+	// DebugHeaderSchedulingPath reports the filters the scheduler applied to the request.
+	DebugHeaderSchedulingPath DebugHeader = "scheduling-path"
+	// DebugHeaderQueueWait reports how long the request waited in the gateway, from receiving its
+	// headers to picking its pod, in milliseconds.
+	DebugHeaderQueueWait DebugHeader = "queue-wait"
+)
+
+// debugHeaderKeys maps the debug headers to their keys in the response.
+var debugHeaderKeys = map[DebugHeader]string{
+	DebugHeaderPod:            "x-gateway-pod",
+	DebugHeaderTargetModel:    "x-gateway-target-model",
+	DebugHeaderCriticality:    "x-gateway-criticality",
+	DebugHeaderSchedulingPath: "x-gateway-scheduling-path",
+	DebugHeaderQueueWait:      "x-gateway-queue-wait-ms",
+}
+
+// Key returns the key of the debug header in the response, or an empty string if the debug header
+// is not supported.
+func (h DebugHeader) Key() string {
+	return debugHeaderKeys[h]
+}
+
+// UnknownModelPolicy defines how requests for models without an InferenceModel are handled.
+type UnknownModelPolicy string
+
+const (
+	// RejectUnknownModels rejects requests for unknown models with a 404 response. This is the
+	// default.
+	RejectUnknownModels UnknownModelPolicy = "reject"
+	// PassthroughUnknownModels sends requests for unknown models to the backend as is.
+	PassthroughUnknownModels UnknownModelPolicy = "passthrough"
+	// FallbackUnknownModels sends requests for unknown models to the fallback model.
+	FallbackUnknownModels UnknownModelPolicy = "fallback"
+)
+
+type ServerOption func(*Server)
+
+// WithSessionHeader sets the request header used to identify the session of a request.
+func WithSessionHeader(header string) ServerOption {
+	return func(s *Server) {
+		s.sessionHeader = header
+	}
+}
+
+// WithModelDemandRecorder sets the recorder notified of the target model of every request.
+func WithModelDemandRecorder(r ModelDemandRecorder) ServerOption {
+	return func(s *Server) {
+		s.demandRecorder = r
+	}
+}
+
+// WithConsistentTrafficSplit picks the target model of a request by hashing the value of the
+// header, or the session ID of the request if the header is not set, so that the requests of a
+// user or a session are consistently sent to the same target model. Requests with neither are
+// split at random.
+func WithConsistentTrafficSplit(header string) ServerOption {
+	return func(s *Server) {
+		s.consistentTrafficSplit = true
+		s.trafficSplitHeader = header
+	}
+}
+
+// WithDebugHeaders sets the optional response headers exposing routing decisions.
+func WithDebugHeaders(headers ...DebugHeader) ServerOption {
+	return func(s *Server) {
+		s.debugHeaders = headers
+	}
+}
+
+// WithTracerProvider traces the requests with the tracer provider. Requests are not traced by
+// default, but their trace context is still propagated to the model servers.
+func WithTracerProvider(tp trace.TracerProvider) ServerOption {
+	return func(s *Server) {
+		s.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// WithAccessLogger records an access log entry for every request with the access logger.
+func WithAccessLogger(l *AccessLogger) ServerOption {
+	return func(s *Server) {
+		s.accessLogger = l
+	}
+}
+
+// WithMaxRequestBodySize rejects the requests whose body is larger than maxBytes with a 413
+// response, before parsing them.
+func WithMaxRequestBodySize(maxBytes int64) ServerOption {
+	return func(s *Server) {
+		s.maxRequestBodyBytes = maxBytes
+	}
+}
+
+// WithUsageRecorder sets the recorder notified of the tokens used by every request.
+func WithUsageRecorder(r UsageRecorder) ServerOption {
+	return func(s *Server) {
+		s.usageRecorder = r
+	}
+}
+
+// WithQuotaLimiter enforces the token quotas of the models with the limiter.
+func WithQuotaLimiter(l *quota.Limiter) ServerOption {
+	return func(s *Server) {
+		s.quotaLimiter = l
+	}
+}
+
+// WithTenantHeader sets the request header identifying the tenant the tokens used by a request are
+// accounted to, for usage recording and per tenant quotas.
+func WithTenantHeader(header string) ServerOption {
+	return func(s *Server) {
+		s.tenantHeader = header
+	}
+}
+
+// WithLatencyObserver sets the observer notified of the latency of the requests whose latency was
+// predicted by the scheduler, e.g. for the scheduler to learn from it.
+func WithLatencyObserver(o LatencyObserver) ServerOption {
+	return func(s *Server) {
+		s.latencyObserver = o
+	}
+}
+
+// WithResponseRecorder sets the recorder notified of the outcome of every response.
+func WithResponseRecorder(r ResponseRecorder) ServerOption {
+	return func(s *Server) {
+		s.responseRecorder = r
+	}
+}
+
+// WithUnknownModelPolicy sets how requests for models without an InferenceModel are handled. The
+// fallback model is only used with the FallbackUnknownModels policy.
+func WithUnknownModelPolicy(policy UnknownModelPolicy, fallbackModel string) ServerOption {
+	return func(s *Server) {
+		s.unknownModelPolicy = policy
+		s.fallbackModel = fallbackModel
+	}
+}
+
+// WithSessionBodyField sets the top-level request body field, such as "user", used to identify
+// the session of a request.
+func WithSessionBodyField(field string) ServerOption {
+	return func(s *Server) {
+		s.sessionBodyField = field
+	}
 }
 
 type Scheduler interface {
@@ -42,6 +249,31 @@ type Scheduler interface {
 type PodProvider interface {
 	GetPodMetrics(pod backend.Pod) (*backend.PodMetrics, bool)
 	UpdatePodMetrics(pod backend.Pod, pm *backend.PodMetrics)
+	// ApplyLoadReport updates the metrics of the pod from the load report of a response.
+	ApplyLoadReport(pod backend.Pod, report *orcav3.OrcaLoadReport)
+}
+
+// ModelDemandRecorder records the demand for target models, e.g. to decide where to load LoRA
+// adapters.
+type ModelDemandRecorder interface {
+	RecordRequest(targetModel string)
+}
+
+// ResponseRecorder records the outcome of the responses of target models, e.g. to roll back the
+// rollout of a target model. The latency is the time to the response headers.
+type ResponseRecorder interface {
+	RecordResponse(model, targetModel string, statusCode int, latency time.Duration)
+}
+
+// UsageRecorder records the tokens used by the requests, e.g. for chargeback.
+type UsageRecorder interface {
+	RecordUsage(model, targetModel, tenant string, promptTokens, completionTokens int)
+}
+
+// LatencyObserver observes the latency of the requests, from their scheduling to their response
+// body, with the features they were scheduled with.
+type LatencyObserver interface {
+	ObserveLatency(features scheduling.LatencyFeatures, latency time.Duration, completionTokens int)
 }
 
 type ModelDataStore interface {
@@ -53,7 +285,19 @@ func (s *Server) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
 	ctx := srv.Context()
 	// Create request context to share states during life time of an HTTP request.
 	// See https://github.com/envoyproxy/envoy/issues/17540.
-	reqCtx := &RequestContext{}
+	reqCtx := &RequestContext{ctx: ctx}
+	defer func() {
+		if reqCtx.span != nil {
+			reqCtx.span.End()
+		}
+		if !reqCtx.served && (reqCtx.Err != nil || reqCtx.StatusCode >= 400) {
+			// Requests the model server did not serve do not count against the quota. Requests it
+			// served count even if processing their response failed, e.g. for a streamed response.
+			// The stream context is done by now.
+			reqCtx.quotaReservation.Release(context.WithoutCancel(reqCtx.context()))
+		}
+		s.logAccess(reqCtx)
+	}()
 
 	for {
 		select {
@@ -76,7 +320,7 @@ func (s *Server) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
 		resp := &extProcPb.ProcessingResponse{}
 		switch v := req.Request.(type) {
 		case *extProcPb.ProcessingRequest_RequestHeaders:
-			resp = HandleRequestHeaders(reqCtx, req)
+			resp = s.HandleRequestHeaders(reqCtx, req)
 			klog.V(3).Infof("Request context after HandleRequestHeaders: %+v", reqCtx)
 		case *extProcPb.ProcessingRequest_RequestBody:
 			resp, err = s.HandleRequestBody(reqCtx, req)
@@ -87,6 +331,9 @@ func (s *Server) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
 		case *extProcPb.ProcessingRequest_ResponseBody:
 			resp, err = s.HandleResponseBody(reqCtx, req)
 			klog.V(3).Infof("Request context after HandleResponseBody: %+v", reqCtx)
+		case *extProcPb.ProcessingRequest_ResponseTrailers:
+			resp, err = s.HandleResponseTrailers(reqCtx, req)
+			klog.V(3).Infof("Request context after HandleResponseTrailers: %+v", reqCtx)
 		default:
 			klog.Errorf("Unknown Request type %+v", v)
 			return status.Error(codes.Unknown, "unknown request type")
@@ -94,22 +341,11 @@ func (s *Server) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
 
 		if err != nil {
 			klog.Errorf("failed to process request: %v", err)
-			switch status.Code(err) {
-			// This code can be returned by scheduler when there is no capacity for sheddable
-			// requests.
-			case codes.ResourceExhausted:
-				resp = &extProcPb.ProcessingResponse{
-					Response: &extProcPb.ProcessingResponse_ImmediateResponse{
-						ImmediateResponse: &extProcPb.ImmediateResponse{
-							Status: &envoyTypePb.HttpStatus{
-								Code: envoyTypePb.StatusCode_TooManyRequests,
-							},
-						},
-					},
-				}
-			default:
-				return status.Errorf(status.Code(err), "failed to handle request: %v", err)
-			}
+			// Return errors to the client instead of failing the stream, which Envoy would turn into
+			// an opaque 500 response.
+			resp = errorResponse(err)
+			reqCtx.Err = err
+			reqCtx.StatusCode = int(errorMappingFor(err).httpStatus)
 		}
 
 		klog.V(3).Infof("response: %v", resp)
@@ -120,9 +356,67 @@ func (s *Server) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
 	}
 }
 
+// CriticalityHeader is the request header clients can set to override the criticality of the
+// requested model. See backend.ResolveCriticality for how the override is applied.
+const CriticalityHeader = "x-llm-criticality"
+
+// ServedModelHeader is the response header reporting the target model which served the request,
+// which differs from the requested target model when the request was sent to a fallback model.
+const ServedModelHeader = "x-served-model"
+
 // RequestContext stores context information during the life time of an HTTP request.
 type RequestContext struct {
+	// ctx is the context of the request, carrying e.g. its tracing span.
+	ctx context.Context
+	// bodyReceived is whether a message with the request body was received.
+	bodyReceived bool
+	// quotaReservation is the tokens reserved for the request in the quota of its model, if any.
+	quotaReservation *quota.Reservation
+	// served is whether the model server responded with a successful status.
+	served bool
+	// latencyPrediction is the latency predicted by the scheduler for the request, if any.
+	latencyPrediction *scheduling.LatencyPrediction
+	// span covers the lifetime of the request, from its headers to the end of the stream.
+	span      trace.Span
 	TargetPod backend.Pod
 	Model     string
-	Response  Response
+	// ModelName is the modelName of the InferenceModel serving the request, which differs from Model
+	// for requests sent to the fallback model.
+	ModelName           string
+	ResolvedTargetModel string
+	// RequestReceivedTimestamp is when the request headers were received.
+	RequestReceivedTimestamp time.Time
+	// Headers are the request headers keyed by lower case name, used to evaluate the rules of the
+	// requested model.
+	Headers map[string]string
+	// RequestedCriticality is the criticality requested by the client with the CriticalityHeader,
+	// if any.
+	RequestedCriticality v1alpha1.Criticality
+	// SessionID identifies the session of the request for session affinity, if any.
+	SessionID string
+	// Criticality is the criticality the request was scheduled with.
+	Criticality v1alpha1.Criticality
+	// SchedulingPath is the names of the scheduler filters applied to the request.
+	SchedulingPath []string
+	// QueueWait is the time from receiving the request headers to picking the target pod.
+	QueueWait time.Duration
+	// TrafficSplitKey is the value of the traffic split header, if any.
+	TrafficSplitKey string
+	// StatusCode is the HTTP status of the response, either returned by the model server or by the
+	// gateway on errors.
+	StatusCode int
+	// ResponseLatency is the time from receiving the request headers to receiving the response
+	// headers.
+	ResponseLatency time.Duration
+	// Err is the error returned to the client by the gateway, if any.
+	Err      error
+	Response Response
+}
+
+// context returns the context of the request.
+func (r *RequestContext) context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
 }
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"sort"
+
+	configPb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/scheduling"
+)
+
+// tracerName identifies the spans of the ext-proc.
+const tracerName = "inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc"
+
+// getTracer returns the tracer of the server, which does not trace if the server was not created
+// by NewServer.
+func (s *Server) getTracer() trace.Tracer {
+	if s.tracer == nil {
+		return noop.Tracer{}
+	}
+	return s.tracer
+}
+
+func (s *Server) getPropagator() propagation.TextMapPropagator {
+	if s.propagator == nil {
+		return propagation.TraceContext{}
+	}
+	return s.propagator
+}
+
+// startRequestSpan starts the span covering the lifetime of the request, as a child of the trace
+// context of the request headers, if any. The span is ended by Process.
+func (s *Server) startRequestSpan(reqCtx *RequestContext) {
+	ctx := s.getPropagator().Extract(reqCtx.context(), propagation.MapCarrier(reqCtx.Headers))
+	ctx, reqCtx.span = s.getTracer().Start(ctx, "ext_proc.request", trace.WithSpanKind(trace.SpanKindServer))
+	reqCtx.ctx = ctx
+}
+
+// startSpan starts a span for a step of the processing of the request.
+func (s *Server) startSpan(reqCtx *RequestContext, name string) trace.Span {
+	_, span := s.getTracer().Start(reqCtx.context(), name)
+	return span
+}
+
+// endSpan ends the span, marking it as failed if err is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceContextHeaders returns the headers propagating the trace context of the request to the
+// model server.
+func (s *Server) traceContextHeaders(reqCtx *RequestContext) []*configPb.HeaderValueOption {
+	carrier := propagation.MapCarrier{}
+	s.getPropagator().Inject(reqCtx.context(), carrier)
+	keys := carrier.Keys()
+	// Sort the keys for a deterministic order of the headers.
+	sort.Strings(keys)
+	var headers []*configPb.HeaderValueOption
+	for _, key := range keys {
+		headers = append(headers, &configPb.HeaderValueOption{
+			Header: &configPb.HeaderValue{
+				Key:      key,
+				RawValue: []byte(carrier.Get(key)),
+			},
+		})
+	}
+	return headers
+}
+
+// addDecisionEvents adds the steps and the outcome of the scheduling decision as events of the
+// span. It is a no-op if the span is not recording.
+func addDecisionEvents(span trace.Span, d *scheduling.Decision) {
+	if d == nil || !span.IsRecording() {
+		return
+	}
+	for _, step := range d.Steps {
+		span.AddEvent("scheduling.filter", trace.WithAttributes(
+			attribute.String("filter", step.Filter),
+			attribute.Int("input_pods", step.InputPods),
+			attribute.Int("output_pods", step.OutputPods),
+			attribute.Bool("passed", step.Passed),
+		))
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("target_model", d.TargetModel),
+		attribute.String("criticality", string(d.Criticality)),
+		attribute.Int("candidates", d.Candidates),
+		attribute.String("target_pod", d.TargetPod),
+	}
+	if d.Error != "" {
+		attrs = append(attrs, attribute.String("error", d.Error))
+	}
+	span.AddEvent("scheduling.decision", trace.WithAttributes(attrs...))
+}
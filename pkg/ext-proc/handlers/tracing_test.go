@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"testing"
+
+	configPb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/google/go-cmp/cmp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+)
+
+func TestTracing(t *testing.T) {
+	const (
+		traceID      = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentSpanID = "00f067aa0ba902b7"
+	)
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	models := map[string]*v1alpha1.InferenceModel{
+		"my-model": {Spec: v1alpha1.InferenceModelSpec{ModelName: "my-model"}},
+	}
+	scheduler := &fakeScheduler{pod: backend.Pod{Name: "pod", Address: "address"}}
+	server := NewServer(nil, scheduler, "target-pod", &backend.FakeDataStore{Res: models}, WithTracerProvider(tp))
+
+	reqCtx := &RequestContext{}
+	server.HandleRequestHeaders(reqCtx, &extProcPb.ProcessingRequest{
+		Request: &extProcPb.ProcessingRequest_RequestHeaders{
+			RequestHeaders: &extProcPb.HttpHeaders{
+				Headers: &configPb.HeaderMap{
+					Headers: []*configPb.HeaderValue{
+						{Key: "traceparent", RawValue: []byte("00-" + traceID + "-" + parentSpanID + "-01")},
+					},
+				},
+			},
+		},
+	})
+	resp, err := server.HandleRequestBody(reqCtx, &extProcPb.ProcessingRequest{
+		Request: &extProcPb.ProcessingRequest_RequestBody{
+			RequestBody: &extProcPb.HttpBody{Body: []byte(`{"model":"my-model","prompt":"hello"}`), EndOfStream: true},
+		},
+	})
+	if err != nil {
+		t.Fatalf("HandleRequestBody returned unexpected error: %v", err)
+	}
+	if _, err := server.HandleResponseHeaders(reqCtx, &extProcPb.ProcessingRequest{
+		Request: &extProcPb.ProcessingRequest_ResponseHeaders{ResponseHeaders: &extProcPb.HttpHeaders{}},
+	}); err != nil {
+		t.Fatalf("HandleResponseHeaders returned unexpected error: %v", err)
+	}
+	// Process ends the request span when the stream ends.
+	reqCtx.span.End()
+
+	spans := exporter.GetSpans()
+	var names []string
+	byName := make(map[string]tracetest.SpanStub)
+	for _, span := range spans {
+		names = append(names, span.Name)
+		byName[span.Name] = span
+		if got := span.SpanContext.TraceID().String(); got != traceID {
+			t.Errorf("Span %v has trace ID %v, want %v", span.Name, got, traceID)
+		}
+	}
+	wantNames := []string{"parse_body", "resolve_model", "schedule", "process_response_headers", "ext_proc.request"}
+	if diff := cmp.Diff(wantNames, names); diff != "" {
+		t.Fatalf("Unexpected spans (-want +got): %v", diff)
+	}
+	root := byName["ext_proc.request"]
+	if got := root.Parent.SpanID().String(); got != parentSpanID {
+		t.Errorf("Request span has parent %v, want %v", got, parentSpanID)
+	}
+	for _, name := range wantNames[:4] {
+		if got := byName[name].Parent.SpanID(); got != root.SpanContext.SpanID() {
+			t.Errorf("Span %v has parent %v, want the request span %v", name, got, root.SpanContext.SpanID())
+		}
+	}
+	if events := byName["schedule"].Events; len(events) != 1 || events[0].Name != "scheduling.decision" {
+		t.Errorf("Unexpected events of the schedule span: %+v", events)
+	}
+
+	// The trace context is propagated to the model server.
+	wantTraceparent := "00-" + traceID + "-" + root.SpanContext.SpanID().String() + "-01"
+	var gotTraceparent string
+	for _, h := range resp.GetRequestBody().GetResponse().GetHeaderMutation().GetSetHeaders() {
+		if h.Header.Key == "traceparent" {
+			gotTraceparent = string(h.Header.RawValue)
+		}
+	}
+	if gotTraceparent != wantTraceparent {
+		t.Errorf("Propagated traceparent %q, want %q", gotTraceparent, wantTraceparent)
+	}
+}
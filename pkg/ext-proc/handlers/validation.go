@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/jsonbody"
+)
+
+// checkBodySize returns an OutOfRange error if the body is larger than maxBytes. A non-positive
+// maxBytes disables the check.
+func checkBodySize(body []byte, maxBytes int64) error {
+	if maxBytes > 0 && int64(len(body)) > maxBytes {
+		return status.Errorf(codes.OutOfRange, "request body of %d bytes exceeds the limit of %d bytes", len(body), maxBytes)
+	}
+	return nil
+}
+
+// validateRequest checks the request for the model against the limits of the model.
+func validateRequest(modelObj *v1alpha1.InferenceModel, body *jsonbody.Body) error {
+	limits := modelObj.Spec.Limits
+	if limits == nil {
+		return nil
+	}
+	if limits.MaxBodyBytes != nil {
+		if err := checkBodySize(body.Raw(), *limits.MaxBodyBytes); err != nil {
+			return err
+		}
+	}
+	if len(limits.AllowedParameters) > 0 {
+		allowed := map[string]bool{"model": true}
+		for _, p := range limits.AllowedParameters {
+			allowed[p] = true
+		}
+		var disallowed []string
+		for _, p := range body.Fields() {
+			if !allowed[p] {
+				disallowed = append(disallowed, p)
+			}
+		}
+		if len(disallowed) > 0 {
+			sort.Strings(disallowed)
+			return withErrorCode(status.Errorf(codes.InvalidArgument, "parameters %q are not allowed for model %q", disallowed, modelObj.Spec.ModelName), "unsupported_parameter")
+		}
+	}
+	if limits.MaxPromptLength != nil {
+		if length := promptLength(body); length > int(*limits.MaxPromptLength) {
+			return withErrorCode(status.Errorf(codes.InvalidArgument, "prompt of %d characters exceeds the limit of %d characters", length, *limits.MaxPromptLength), "context_length_exceeded")
+		}
+	}
+	if limits.MaxTokens != nil {
+		for _, p := range []string{"max_tokens", "max_completion_tokens"} {
+			if v, ok := body.Number(p); ok && v > float64(*limits.MaxTokens) {
+				return withErrorCode(status.Errorf(codes.InvalidArgument, "%s of %v exceeds the limit of %d", p, v, *limits.MaxTokens), "invalid_value")
+			}
+		}
+	}
+	return nil
+}
+
+// promptLength returns the length in characters of the prompt of a completion request, which is a
+// string or a list of strings, or of the content of the messages of a chat completion request,
+// which is a string or a list of text parts.
+func promptLength(body *jsonbody.Body) int {
+	prompt, _ := body.Value("prompt")
+	length := textLength(prompt)
+	messages, _ := body.Value("messages")
+	if messages, ok := messages.([]interface{}); ok {
+		for _, m := range messages {
+			if message, ok := m.(map[string]interface{}); ok {
+				length += textLength(message["content"])
+			}
+		}
+	}
+	return length
+}
+
+func textLength(v interface{}) int {
+	switch v := v.(type) {
+	case string:
+		return utf8.RuneCountInString(v)
+	case []interface{}:
+		length := 0
+		for _, item := range v {
+			if part, ok := item.(map[string]interface{}); ok {
+				item = part["text"]
+			}
+			if s, ok := item.(string); ok {
+				length += utf8.RuneCountInString(s)
+			}
+		}
+		return length
+	}
+	return 0
+}
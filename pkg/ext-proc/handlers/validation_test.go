@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	envoyTypePb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"k8s.io/utils/ptr"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+)
+
+func TestHandleRequestBodyLimits(t *testing.T) {
+	limits := &v1alpha1.RequestLimits{
+		MaxBodyBytes:      ptr.To[int64](200),
+		MaxPromptLength:   ptr.To[int32](10),
+		MaxTokens:         ptr.To[int32](100),
+		AllowedParameters: []string{"prompt", "messages", "max_tokens", "max_completion_tokens"},
+	}
+	tests := []struct {
+		name string
+		// maxRequestBodyBytes is the limit of the server, regardless of the model.
+		maxRequestBodyBytes int64
+		limits              *v1alpha1.RequestLimits
+		body                string
+		wantStatus          envoyTypePb.StatusCode
+		wantCode            string
+	}{
+		{
+			name:   "no limits",
+			limits: nil,
+			body:   `{"model":"my-model","prompt":"a very long prompt","max_tokens":1000,"temperature":0}`,
+		},
+		{
+			name:   "within limits",
+			limits: limits,
+			body:   `{"model":"my-model","prompt":"hello","max_tokens":100}`,
+		},
+		{
+			name:                "body larger than the server limit",
+			maxRequestBodyBytes: 10,
+			body:                `{"model":"my-model","prompt":"hello"}`,
+			wantStatus:          envoyTypePb.StatusCode_PayloadTooLarge,
+			wantCode:            "request_too_large",
+		},
+		{
+			name:       "body larger than the model limit",
+			limits:     limits,
+			body:       `{"model":"my-model","prompt":"` + strings.Repeat("a", 200) + `"}`,
+			wantStatus: envoyTypePb.StatusCode_PayloadTooLarge,
+			wantCode:   "request_too_large",
+		},
+		{
+			name:       "disallowed parameter",
+			limits:     limits,
+			body:       `{"model":"my-model","prompt":"hello","temperature":0}`,
+			wantStatus: envoyTypePb.StatusCode_BadRequest,
+			wantCode:   "unsupported_parameter",
+		},
+		{
+			name:       "prompt too long",
+			limits:     limits,
+			body:       `{"model":"my-model","prompt":["hello","world!"]}`,
+			wantStatus: envoyTypePb.StatusCode_BadRequest,
+			wantCode:   "context_length_exceeded",
+		},
+		{
+			name:       "chat messages too long",
+			limits:     limits,
+			body:       `{"model":"my-model","messages":[{"role":"system","content":"héllo"},{"role":"user","content":[{"type":"text","text":"world!"}]}]}`,
+			wantStatus: envoyTypePb.StatusCode_BadRequest,
+			wantCode:   "context_length_exceeded",
+		},
+		{
+			name:       "max_completion_tokens too large",
+			limits:     limits,
+			body:       `{"model":"my-model","prompt":"hello","max_completion_tokens":101}`,
+			wantStatus: envoyTypePb.StatusCode_BadRequest,
+			wantCode:   "invalid_value",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			model := &v1alpha1.InferenceModel{
+				Spec: v1alpha1.InferenceModelSpec{ModelName: "my-model", Limits: test.limits},
+			}
+			scheduler := &fakeScheduler{pod: backend.Pod{Name: "pod", Address: "address"}}
+			server := NewServer(nil, scheduler, "target-pod", &backend.FakeDataStore{Res: map[string]*v1alpha1.InferenceModel{"my-model": model}},
+				WithMaxRequestBodySize(test.maxRequestBodyBytes))
+			req := &extProcPb.ProcessingRequest{
+				Request: &extProcPb.ProcessingRequest_RequestBody{
+					RequestBody: &extProcPb.HttpBody{Body: []byte(test.body), EndOfStream: true},
+				},
+			}
+			_, err := server.HandleRequestBody(&RequestContext{}, req)
+			if test.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Expected an error with status %v", test.wantStatus)
+			}
+			resp := errorResponse(err).GetImmediateResponse()
+			if resp.GetStatus().GetCode() != test.wantStatus {
+				t.Errorf("Unexpected status, got %v, want %v", resp.GetStatus().GetCode(), test.wantStatus)
+			}
+			var body openAIError
+			if err := json.Unmarshal(resp.GetBody(), &body); err != nil {
+				t.Fatalf("Failed to unmarshal error body: %v", err)
+			}
+			if body.Error.Code != test.wantCode {
+				t.Errorf("Unexpected error code, got %q, want %q", body.Error.Code, test.wantCode)
+			}
+		})
+	}
+}
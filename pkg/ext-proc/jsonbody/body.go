@@ -0,0 +1,200 @@
+// Package jsonbody locates and rewrites the top-level fields of JSON request bodies without decoding
+// them, so that large prompts are neither decoded nor re-encoded.
+package jsonbody
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// Body is a JSON object whose top-level fields are located without decoding their values.
+type Body struct {
+	raw    []byte
+	fields []field
+}
+
+// field is a top-level field of a body. The value is a sub-slice of the raw body.
+type field struct {
+	name  string
+	start int
+	end   int
+}
+
+// Parse locates the top-level fields of the JSON object. It returns an error if raw is not valid
+// JSON or not an object.
+func Parse(raw []byte) (*Body, error) {
+	// Validating does not allocate, and lets the scanner below assume the JSON is well formed.
+	if !json.Valid(raw) {
+		return nil, errors.New("invalid JSON")
+	}
+	b := &Body{raw: raw}
+	i := skipSpace(raw, 0)
+	if raw[i] != '{' {
+		return nil, errors.New("JSON body is not an object")
+	}
+	i = skipSpace(raw, i+1)
+	for raw[i] != '}' {
+		nameEnd := skipString(raw, i)
+		name, err := unquote(raw[i:nameEnd])
+		if err != nil {
+			return nil, err
+		}
+		// Skip the colon.
+		start := skipSpace(raw, skipSpace(raw, nameEnd)+1)
+		end := skipValue(raw, start)
+		b.fields = append(b.fields, field{name: name, start: start, end: end})
+		i = skipSpace(raw, end)
+		if raw[i] == ',' {
+			i = skipSpace(raw, i+1)
+		}
+	}
+	return b, nil
+}
+
+// Raw returns the raw body.
+func (b *Body) Raw() []byte {
+	return b.raw
+}
+
+// Fields returns the names of the top-level fields, in order.
+func (b *Body) Fields() []string {
+	names := make([]string, len(b.fields))
+	for i, f := range b.fields {
+		names[i] = f.name
+	}
+	return names
+}
+
+// lookup returns the field with the name. Like encoding/json, the last field wins if the name is
+// duplicated.
+func (b *Body) lookup(name string) (field, bool) {
+	for i := len(b.fields) - 1; i >= 0; i-- {
+		if b.fields[i].name == name {
+			return b.fields[i], true
+		}
+	}
+	return field{}, false
+}
+
+// RawValue returns the raw JSON value of the field, and whether the field is set.
+func (b *Body) RawValue(name string) ([]byte, bool) {
+	f, ok := b.lookup(name)
+	if !ok {
+		return nil, false
+	}
+	return b.raw[f.start:f.end], true
+}
+
+// String returns the value of the field if it is a string.
+func (b *Body) String(name string) (string, bool) {
+	raw, ok := b.RawValue(name)
+	if !ok || raw[0] != '"' {
+		return "", false
+	}
+	s, err := unquote(raw)
+	return s, err == nil
+}
+
+// Number returns the value of the field if it is a number.
+func (b *Body) Number(name string) (float64, bool) {
+	raw, ok := b.RawValue(name)
+	if !ok || (raw[0] != '-' && (raw[0] < '0' || raw[0] > '9')) {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(string(raw), 64)
+	return v, err == nil
+}
+
+// Value decodes the value of the field as encoding/json decodes it into an interface{}, and
+// returns whether the field is set.
+func (b *Body) Value(name string) (any, bool) {
+	raw, ok := b.RawValue(name)
+	if !ok {
+		return nil, false
+	}
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, false
+	}
+	return v, true
+}
+
+// WithString returns a copy of the raw body with the value of the field replaced by the string,
+// keeping the other fields as is and in order. It returns the raw body if the field is not set.
+func (b *Body) WithString(name, value string) []byte {
+	f, ok := b.lookup(name)
+	if !ok {
+		return b.raw
+	}
+	quoted, _ := json.Marshal(value)
+	out := make([]byte, 0, len(b.raw)-(f.end-f.start)+len(quoted))
+	out = append(out, b.raw[:f.start]...)
+	out = append(out, quoted...)
+	return append(out, b.raw[f.end:]...)
+}
+
+func skipSpace(raw []byte, i int) int {
+	for i < len(raw) && (raw[i] == ' ' || raw[i] == '\t' || raw[i] == '\n' || raw[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// skipString returns the index after the string starting at i.
+func skipString(raw []byte, i int) int {
+	for i++; raw[i] != '"'; i++ {
+		if raw[i] == '\\' {
+			i++
+		}
+	}
+	return i + 1
+}
+
+// skipValue returns the index after the value starting at i.
+func skipValue(raw []byte, i int) int {
+	switch raw[i] {
+	case '"':
+		return skipString(raw, i)
+	case '{', '[':
+		depth := 0
+		for ; ; i++ {
+			switch raw[i] {
+			case '"':
+				i = skipString(raw, i) - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1
+				}
+			}
+		}
+	default:
+		// Numbers and literals end at a delimiter.
+		for i < len(raw) && raw[i] != ',' && raw[i] != '}' && raw[i] != ']' &&
+			raw[i] != ' ' && raw[i] != '\t' && raw[i] != '\n' && raw[i] != '\r' {
+			i++
+		}
+		return i
+	}
+}
+
+// unquote decodes a JSON string, with a plain copy if it has no escape sequences.
+func unquote(quoted []byte) (string, error) {
+	s := quoted[1 : len(quoted)-1]
+	escaped := false
+	for _, c := range s {
+		if c == '\\' {
+			escaped = true
+			break
+		}
+	}
+	if !escaped {
+		return string(s), nil
+	}
+	var v string
+	err := json.Unmarshal(quoted, &v)
+	return v, err
+}
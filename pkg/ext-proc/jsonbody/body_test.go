@@ -0,0 +1,154 @@
+package jsonbody
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantErr    bool
+		wantFields []string
+		wantModel  string
+	}{
+		{
+			name:       "completion request",
+			body:       `{"model":"my-model","prompt":"hello","max_tokens":100,"temperature":0}`,
+			wantFields: []string{"model", "prompt", "max_tokens", "temperature"},
+			wantModel:  "my-model",
+		},
+		{
+			name: "nested values, escapes and whitespace",
+			body: ` {
+				"messages": [{"role": "user", "content": "say \"}]\" \\"}, {"role": "assistant", "content": ["{", "["]}],
+				"stream" : true , "stop": null, "logit_bias": {"50256": -100},
+				"model": "my-model"
+			} `,
+			wantFields: []string{"messages", "stream", "stop", "logit_bias", "model"},
+			wantModel:  "my-model",
+		},
+		{
+			name:       "duplicate fields",
+			body:       `{"model":"first","model":"last"}`,
+			wantFields: []string{"model", "model"},
+			wantModel:  "last",
+		},
+		{
+			name:       "empty object",
+			body:       `{}`,
+			wantFields: []string{},
+		},
+		{
+			name:    "malformed",
+			body:    `{"model":"my-model"`,
+			wantErr: true,
+		},
+		{
+			name:    "not an object",
+			body:    `["model"]`,
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := Parse([]byte(test.body))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(test.wantFields, body.Fields()); diff != "" {
+				t.Errorf("Unexpected fields (-want +got): %v", diff)
+			}
+			if model, _ := body.String("model"); model != test.wantModel {
+				t.Errorf("Unexpected model, got %q, want %q", model, test.wantModel)
+			}
+		})
+	}
+}
+
+func TestValues(t *testing.T) {
+	body, err := Parse([]byte(`{"model":"my-model","max_tokens":1e2,"stream":true,"user":"café","stop":["\n"]}`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if v, ok := body.Number("max_tokens"); !ok || v != 100 {
+		t.Errorf("Number(max_tokens) = %v, %v, want 100", v, ok)
+	}
+	if v, ok := body.Number("model"); ok {
+		t.Errorf("Number(model) = %v, want no number", v)
+	}
+	if v, ok := body.String("user"); !ok || v != "café" {
+		t.Errorf("String(user) = %q, %v, want café", v, ok)
+	}
+	if v, ok := body.String("stream"); ok {
+		t.Errorf("String(stream) = %q, want no string", v)
+	}
+	if v, ok := body.Value("stream"); !ok || v != true {
+		t.Errorf("Value(stream) = %v, %v, want true", v, ok)
+	}
+	if diff := cmp.Diff([]any{"\n"}, must(body.Value("stop"))); diff != "" {
+		t.Errorf("Unexpected Value(stop) (-want +got): %v", diff)
+	}
+	if _, ok := body.Value("missing"); ok {
+		t.Error("Value(missing) is set")
+	}
+}
+
+func must(v any, _ bool) any {
+	return v
+}
+
+func TestWithString(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		field string
+		value string
+		want  string
+	}{
+		{
+			name:  "keeps the other fields as is",
+			body:  `{"prompt": "hello", "model" : "my-model", "temperature":0.50}`,
+			field: "model",
+			value: "my-model-v1",
+			want:  `{"prompt": "hello", "model" : "my-model-v1", "temperature":0.50}`,
+		},
+		{
+			name:  "escapes the value",
+			body:  `{"model":"my-model"}`,
+			field: "model",
+			value: `quoted "model"`,
+			want:  `{"model":"quoted \"model\""}`,
+		},
+		{
+			name:  "replaces the last duplicate",
+			body:  `{"model":"first","model":"last"}`,
+			field: "model",
+			value: "new",
+			want:  `{"model":"first","model":"new"}`,
+		},
+		{
+			name:  "missing field",
+			body:  `{"prompt":"hello"}`,
+			field: "model",
+			value: "new",
+			want:  `{"prompt":"hello"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := Parse([]byte(test.body))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := string(body.WithString(test.field, test.value)); got != test.want {
+				t.Errorf("WithString() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -5,12 +5,20 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	healthPb "google.golang.org/grpc/health/grpc_health_v1"
@@ -21,10 +29,16 @@ import (
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	klog "k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend/loadreport"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend/lora"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend/rollout"
 	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend/vllm"
 	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/handlers"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/metering"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/quota"
 	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/scheduling"
 )
 
@@ -37,6 +51,46 @@ var (
 	zone                   = flag.String("zone", "", "The zone that this instance is created in. Will be passed to the corresponding endpointSlice. ")
 	refreshPodsInterval    = flag.Duration("refreshPodsInterval", 10*time.Second, "interval to refresh pods")
 	refreshMetricsInterval = flag.Duration("refreshMetricsInterval", 50*time.Millisecond, "interval to refresh metrics")
+	maxScrapeInterval      = flag.Duration("maxScrapeInterval", 0, "maximum interval to refresh the metrics of idle pods, which grows from refreshMetricsInterval while the pods are idle. Busy pods are refreshed every refreshMetricsInterval. Defaults to refreshMetricsInterval.")
+	maxConcurrentScrapes   = flag.Int("maxConcurrentScrapes", 100, "maximum number of pods whose metrics are refreshed at the same time, or 0 for no limit")
+	sessionHeader          = flag.String("sessionHeader", "", "The request header identifying the session of a request. Requests of the same session are routed to the same pod while it's not overloaded. Session affinity is disabled if neither sessionHeader nor sessionBodyField is set.")
+	sessionBodyField       = flag.String("sessionBodyField", "", "The top-level request body field, such as \"user\", identifying the session of a request. Used if the sessionHeader is not set on the request.")
+	sessionTTL             = flag.Duration("sessionTTL", 10*time.Minute, "duration after which a session without requests is no longer pinned to a pod")
+	maxSessions            = flag.Int("maxSessions", 100000, "maximum number of sessions pinned to pods")
+	unknownModelPolicy     = flag.String("unknownModelPolicy", string(handlers.RejectUnknownModels), "how to handle requests for models without an InferenceModel: \"reject\" them with a 404 response, \"passthrough\" them to the backend as is, or send them to the \"fallback\" model")
+	fallbackModel          = flag.String("fallbackModel", "", "the model requests for unknown models are sent to with the \"fallback\" unknownModelPolicy")
+	enableLoRAManager      = flag.Bool("enableLoRAManager", false, "whether to load and unload LoRA adapters on the model servers based on the InferenceModels and the demand for them. The model servers must allow runtime LoRA updating, and loraBaseModels must be set.")
+	loraReconcileInterval  = flag.Duration("loraReconcileInterval", 10*time.Second, "interval to reconcile the LoRA adapters loaded on the model servers")
+	loraAdapterSourceDir   = flag.String("loraAdapterSourceDir", "", "the directory LoRA adapters are loaded from by the model servers, as <dir>/<adapter name>. If empty, the adapter name is used as the source.")
+	loraBaseModels         = flag.String("loraBaseModels", "", "comma separated list of target models served by base models rather than LoRA adapters, which are never loaded or unloaded. If set, default and sheddable requests for the other target models are only sent to pods with the adapter loaded or a free adapter slot, and fall back to the fallback models of their InferenceModel otherwise.")
+	loraMaxActions         = flag.Int("loraMaxActionsPerReconcile", 10, "maximum number of LoRA adapters loaded or unloaded in one reconciliation")
+	trafficSplitMode       = flag.String("trafficSplitMode", "random", "how requests are split between the target models of an InferenceModel: at \"random\", or \"consistent\"ly by hashing the trafficSplitHeader or the session of the request, so that a user or a session is always sent to the same target model")
+	trafficSplitHeader     = flag.String("trafficSplitHeader", "", "the request header, such as a user ID, hashed to split requests between target models with the \"consistent\" trafficSplitMode. If not set on a request, the session of the request is hashed.")
+	debugResponseHeaders   = flag.String("debugResponseHeaders", "", "comma separated list of response headers exposing routing decisions for debugging, among \"pod\", \"target-model\", \"criticality\", \"scheduling-path\", and \"queue-wait\"")
+	debugPort              = flag.Int("debugPort", 0, "port of the debug HTTP server serving the last scheduling decisions at /debug/scheduler/decisions. Disabled if 0.")
+	decisionLogSize        = flag.Int("decisionLogSize", 100, "number of the last scheduling decisions kept for the debug HTTP server")
+	decisionLogSampleRate  = flag.Float64("decisionLogSampleRate", 0, "fraction, between 0 and 1, of the scheduling decisions which are logged")
+	schedulingPolicy       = flag.String("schedulingPolicy", "filter", "how pods are picked for the requests: 'filter' picks a pod at random among the pods passing the filter tree, 'latency' picks the pod with the lowest latency predicted by a model learned from the observed latencies")
+	tracingExporter        = flag.String("tracingExporter", "none", "where the traces of the requests are exported: \"none\" to only propagate the trace context of the requests to the model servers, or \"otlp\" to export them to the otlpEndpoint over gRPC")
+	otlpEndpoint           = flag.String("otlpEndpoint", "localhost:4317", "the host:port of the OTLP gRPC endpoint traces are exported to")
+	otlpInsecure           = flag.Bool("otlpInsecure", false, "whether to export traces to the otlpEndpoint without TLS")
+	tracingSampleRatio     = flag.Float64("tracingSampleRatio", 1, "fraction, between 0 and 1, of the requests without a sampled parent trace which are traced")
+	accessLog              = flag.String("accessLog", "", "where an access log entry is written as a JSON line for every request: \"stdout\", or the path of a file the entries are appended to. Disabled if empty.")
+	accessLogSampleRate    = flag.Float64("accessLogSampleRate", 1, "fraction, between 0 and 1, of the successful requests written to the access log. Failed requests are always written.")
+	accessLogRedactFields  = flag.String("accessLogRedactFields", "", "comma separated list of access log fields whose values are redacted, among \"model\", \"target_model\", \"pod\", \"session_id\", \"traffic_split_key\", and \"error\"")
+	maxRequestBodyBytes    = flag.Int64("maxRequestBodyBytes", 0, "maximum size of the request bodies, in bytes. Larger requests are rejected with a 413 response before they are parsed. InferenceModels can set lower limits. Disabled if 0.")
+	meteringSink           = flag.String("meteringSink", "", "where the token usage of the requests, aggregated per model, target model and tenant, is flushed after every meteringWindow: the path of a file the records are appended to as JSON lines, or an http(s) URL the records are posted to. Disabled if empty.")
+	meteringWindow         = flag.Duration("meteringWindow", time.Minute, "the time window the token usage is aggregated over")
+	meteringSpoolDir       = flag.String("meteringSpoolDir", "/var/lib/ext-proc/metering", "the directory the token usage records are kept in until the meteringSink accepts them. It should be persistent so that the records are not lost on restarts.")
+	meteringInstance       = flag.String("meteringInstance", "", "the ID of this replica in the token usage records, which must be unique among the replicas. Defaults to the hostname, which is the pod name in Kubernetes.")
+	tenantHeader           = flag.String("tenantHeader", "", "the request header identifying the tenant the token usage of a request is accounted to, for metering and per tenant quotas")
+	enableQuotas           = flag.Bool("enableQuotas", false, "whether to reject the requests for InferenceModels whose token quota is exhausted")
+	quotaStore             = flag.String("quotaStore", "memory", "where the token usage counted against the quotas is kept: \"memory\", so that each replica enforces the quotas separately, or a redis://[:password@]host:port[/db] or rediss:// URL of a Redis shared by the replicas")
+	enableRollouts         = flag.Bool("enableRollouts", false, "whether to progressively shift traffic to the rollout target models of the InferenceModels, and roll them back based on the responses observed by this ext-proc")
+	rolloutInterval        = flag.Duration("rolloutInterval", 10*time.Second, "interval to advance the rollouts of the InferenceModels")
+	enableLoadReports      = flag.Bool("enableLoadReports", false, "whether to accept the ORCA load reports pushed by the model servers on the loadReportPort, from the addresses of their pods. The metrics of the pods which push are no longer polled.")
+	loadReportPort         = flag.Int("loadReportPort", 9004, "gRPC port the load reports of the model servers are pushed to, separate from the port of Envoy")
+	pushedMetricsTTL       = flag.Duration("pushedMetricsTTL", time.Second, "duration after the last load report of a pod with all its metrics after which its metrics are polled again")
 	scheme                 = runtime.NewScheme()
 )
 
@@ -130,11 +184,168 @@ func main() {
 
 	s := grpc.NewServer()
 
-	pp := backend.NewProvider(&vllm.PodMetricsClientImpl{}, datastore)
+	providerOpts := []backend.ProviderOption{
+		backend.WithMaxScrapeInterval(*maxScrapeInterval),
+		backend.WithMaxConcurrentScrapes(*maxConcurrentScrapes),
+	}
+	if *enableLoadReports {
+		providerOpts = append(providerOpts, backend.WithPushedMetricsTTL(*pushedMetricsTTL))
+	}
+	pp := backend.NewProvider(&vllm.PodMetricsClientImpl{}, datastore, providerOpts...)
+	if *enableLoadReports {
+		startLoadReportServer(*loadReportPort, pp)
+	}
 	if err := pp.Init(*refreshPodsInterval, *refreshMetricsInterval); err != nil {
 		klog.Fatalf("failed to initialize: %v", err)
 	}
-	extProcPb.RegisterExternalProcessorServer(s, handlers.NewServer(pp, scheduling.NewScheduler(pp), *targetPodHeader, datastore))
+	switch policy := handlers.UnknownModelPolicy(*unknownModelPolicy); policy {
+	case handlers.RejectUnknownModels, handlers.PassthroughUnknownModels:
+	case handlers.FallbackUnknownModels:
+		if *fallbackModel == "" {
+			klog.Fatalf("fallbackModel must be set with the %q unknownModelPolicy", policy)
+		}
+	default:
+		klog.Fatalf("unsupported unknownModelPolicy %q", policy)
+	}
+	serverOpts := []handlers.ServerOption{
+		handlers.WithSessionHeader(*sessionHeader),
+		handlers.WithSessionBodyField(*sessionBodyField),
+		handlers.WithUnknownModelPolicy(handlers.UnknownModelPolicy(*unknownModelPolicy), *fallbackModel),
+	}
+	tp, shutdownTracing, err := initTracing()
+	if err != nil {
+		klog.Fatalf("failed to initialize tracing: %v", err)
+	}
+	serverOpts = append(serverOpts, handlers.WithTracerProvider(tp))
+	if *debugResponseHeaders != "" {
+		var debugHeaders []handlers.DebugHeader
+		for _, h := range strings.Split(*debugResponseHeaders, ",") {
+			debugHeader := handlers.DebugHeader(strings.TrimSpace(h))
+			if debugHeader.Key() == "" {
+				klog.Fatalf("unsupported debugResponseHeaders %q", h)
+			}
+			debugHeaders = append(debugHeaders, debugHeader)
+		}
+		serverOpts = append(serverOpts, handlers.WithDebugHeaders(debugHeaders...))
+	}
+	if *accessLog != "" {
+		var sink handlers.AccessLogSink
+		if *accessLog == "stdout" {
+			sink = handlers.NewJSONLinesSink(os.Stdout)
+		} else if sink, err = handlers.OpenAccessLogFile(*accessLog); err != nil {
+			klog.Fatalf("failed to open access log: %v", err)
+		}
+		var redactFields []string
+		for _, field := range strings.Split(*accessLogRedactFields, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				redactFields = append(redactFields, field)
+			}
+		}
+		accessLogger, err := handlers.NewAccessLogger(sink, *accessLogSampleRate, redactFields)
+		if err != nil {
+			klog.Fatalf("invalid access log settings: %v", err)
+		}
+		serverOpts = append(serverOpts, handlers.WithAccessLogger(accessLogger))
+	}
+	switch *trafficSplitMode {
+	case "random":
+	case "consistent":
+		serverOpts = append(serverOpts, handlers.WithConsistentTrafficSplit(*trafficSplitHeader))
+	default:
+		klog.Fatalf("unsupported trafficSplitMode %q", *trafficSplitMode)
+	}
+	var baseModels []string
+	if *loraBaseModels != "" {
+		baseModels = strings.Split(*loraBaseModels, ",")
+	}
+	if *enableLoRAManager {
+		// Without base models, every target model would be managed as an adapter, including the
+		// base models, which cannot be loaded.
+		if baseModels == nil {
+			klog.Fatalf("loraBaseModels must be set if enableLoRAManager is set")
+		}
+		loraManager := lora.NewManager(pp, datastore, &vllm.AdapterClientImpl{}, lora.Config{
+			SourceDir:              *loraAdapterSourceDir,
+			BaseModels:             baseModels,
+			MaxActionsPerReconcile: *loraMaxActions,
+		})
+		loraManager.Init(*loraReconcileInterval)
+		serverOpts = append(serverOpts, handlers.WithModelDemandRecorder(loraManager))
+	}
+	serverOpts = append(serverOpts, handlers.WithTenantHeader(*tenantHeader), handlers.WithMaxRequestBodySize(*maxRequestBodyBytes))
+	if *enableQuotas {
+		var store quota.Store = quota.NewMemoryStore()
+		if *quotaStore != "memory" {
+			if store, err = quota.NewRedisStore(*quotaStore); err != nil {
+				klog.Fatalf("invalid quotaStore: %v", err)
+			}
+		}
+		serverOpts = append(serverOpts, handlers.WithQuotaLimiter(quota.NewLimiter(store)))
+	}
+	closeMeter := func() {}
+	if *meteringSink != "" {
+		var sink metering.Sink
+		if strings.HasPrefix(*meteringSink, "http://") || strings.HasPrefix(*meteringSink, "https://") {
+			sink = metering.NewWebhookSink(*meteringSink)
+		} else {
+			sink = metering.NewFileSink(*meteringSink)
+		}
+		instance := *meteringInstance
+		if instance == "" {
+			if instance, err = os.Hostname(); err != nil {
+				klog.Fatalf("failed to get the hostname for meteringInstance: %v", err)
+			}
+		}
+		meter, err := metering.NewMeter(sink, *meteringWindow, *meteringSpoolDir, instance)
+		if err != nil {
+			klog.Fatalf("failed to initialize metering: %v", err)
+		}
+		metering.RegisterMetrics(ctrlmetrics.Registry)
+		meter.Init()
+		closeMeter = meter.Close
+		serverOpts = append(serverOpts, handlers.WithUsageRecorder(meter))
+	}
+	if *enableRollouts {
+		tracker := rollout.NewTracker()
+		rollout.NewController(mgr.GetClient(), datastore, tracker).Init(*rolloutInterval)
+		serverOpts = append(serverOpts, handlers.WithResponseRecorder(tracker))
+	}
+	schedulerOpts := []scheduling.SchedulerOption{
+		scheduling.WithDecisionLogSampling(*decisionLogSampleRate),
+	}
+	if *decisionLogSize < 0 {
+		klog.Fatalf("decisionLogSize must not be negative, got %d", *decisionLogSize)
+	}
+	if *debugPort != 0 {
+		decisions := scheduling.NewDecisionLog(*decisionLogSize)
+		schedulerOpts = append(schedulerOpts, scheduling.WithDecisionLog(decisions))
+		startDebugServer(*debugPort, decisions)
+	}
+	if baseModels != nil {
+		schedulerOpts = append(schedulerOpts, scheduling.WithBaseModels(baseModels))
+	}
+	if *maxSessions <= 0 {
+		klog.Fatalf("maxSessions must be positive, got %d", *maxSessions)
+	}
+	if *sessionHeader != "" || *sessionBodyField != "" {
+		schedulerOpts = append(schedulerOpts, scheduling.WithSessionAffinity(*sessionTTL, *maxSessions))
+	}
+	switch *schedulingPolicy {
+	case "filter":
+	case "latency":
+		predictor := scheduling.NewLatencyPredictor()
+		schedulerOpts = append(schedulerOpts, scheduling.WithLatencyPredictor(predictor))
+		serverOpts = append(serverOpts, handlers.WithLatencyObserver(predictor))
+	default:
+		klog.Fatalf("unsupported schedulingPolicy %q", *schedulingPolicy)
+	}
+	extProcPb.RegisterExternalProcessorServer(s, handlers.NewServer(
+		pp,
+		scheduling.NewScheduler(pp, schedulerOpts...),
+		*targetPodHeader,
+		datastore,
+		serverOpts...,
+	))
 	healthPb.RegisterHealthServer(s, &healthServer{})
 
 	klog.Infof("Starting gRPC server on port :%v", *port)
@@ -147,9 +358,13 @@ func main() {
 		select {
 		case sig := <-gracefulStop:
 			klog.Infof("caught sig: %+v", sig)
+			shutdownTracing()
+			closeMeter()
 			os.Exit(0)
 		case err := <-errChan:
 			klog.Infof("caught error in controller: %+v", err)
+			shutdownTracing()
+			closeMeter()
 			os.Exit(0)
 		}
 
@@ -158,3 +373,64 @@ func main() {
 	s.Serve(lis)
 
 }
+
+// startDebugServer serves debugging information, such as the last scheduling decisions, over HTTP.
+func startDebugServer(port int, decisions *scheduling.DecisionLog) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/scheduler/decisions", decisions)
+	go func() {
+		klog.Infof("Starting debug HTTP server on port :%v", port)
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+			klog.Errorf("Debug HTTP server failed: %v", err)
+		}
+	}()
+}
+
+// startLoadReportServer serves the load reports pushed by the model servers.
+func startLoadReportServer(port int, pp *backend.Provider) {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		klog.Fatalf("failed to listen for load reports: %v", err)
+	}
+	s := grpc.NewServer()
+	loadreport.Register(s, pp)
+	go func() {
+		klog.Infof("Starting load report gRPC server on port :%v", port)
+		if err := s.Serve(lis); err != nil {
+			klog.Errorf("Load report gRPC server failed: %v", err)
+		}
+	}()
+}
+
+// initTracing returns the tracer provider exporting the traces of the requests as configured by
+// the flags, and a function flushing the pending traces.
+func initTracing() (trace.TracerProvider, func(), error) {
+	switch *tracingExporter {
+	case "none":
+		return noop.NewTracerProvider(), func() {}, nil
+	case "otlp":
+	default:
+		return nil, nil, fmt.Errorf("unsupported tracingExporter %q", *tracingExporter)
+	}
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(*otlpEndpoint)}
+	if *otlpInsecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(context.Background(), opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*tracingSampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("inference-gateway-ext-proc"))),
+	)
+	shutdown := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tp.Shutdown(ctx); err != nil {
+			klog.Errorf("Failed to flush traces: %v", err)
+		}
+	}
+	return tp, shutdown, nil
+}
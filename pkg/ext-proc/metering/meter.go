@@ -0,0 +1,310 @@
+// Package metering aggregates the tokens used by the requests for chargeback.
+package metering
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	klog "k8s.io/klog/v2"
+)
+
+const (
+	spoolFileSuffix = ".json"
+	// quarantineSuffix is appended to the names of the spool files which cannot be read, so that
+	// they are kept for inspection but no longer block the other spool files.
+	quarantineSuffix = ".corrupt"
+	// sinkTimeout bounds how long a flush of a window to the sink may take.
+	sinkTimeout = 30 * time.Second
+)
+
+var (
+	// The tenants are not a label since they are set by the clients, and would make the number of
+	// series unbounded. They are only in the records.
+	labels = []string{"model_name", "target_model_name"}
+
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "inference_model_metered_requests_total",
+		Help: "Number of requests whose token usage was metered.",
+	}, labels)
+	promptTokensTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "inference_model_prompt_tokens_total",
+		Help: "Number of prompt tokens used by the requests.",
+	}, labels)
+	completionTokensTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "inference_model_completion_tokens_total",
+		Help: "Number of completion tokens generated for the requests.",
+	}, labels)
+
+	registerOnce sync.Once
+)
+
+// RegisterMetrics registers the token usage counters with the registerer.
+func RegisterMetrics(r prometheus.Registerer) {
+	registerOnce.Do(func() {
+		r.MustRegister(requestsTotal, promptTokensTotal, completionTokensTotal)
+	})
+}
+
+// Key identifies what token usage is aggregated by.
+type Key struct {
+	Model       string `json:"model"`
+	TargetModel string `json:"targetModel"`
+	// Tenant is the value of the tenant header of the requests, if any.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Usage is the aggregated token usage of requests.
+type Usage struct {
+	Requests         int64 `json:"requests"`
+	PromptTokens     int64 `json:"promptTokens"`
+	CompletionTokens int64 `json:"completionTokens"`
+}
+
+// Record is the token usage of a key over a time window, metered by a gateway replica. Records may
+// be delivered more than once, e.g. if the gateway restarts while flushing them, and sinks can
+// deduplicate them by ID.
+type Record struct {
+	ID          string    `json:"id"`
+	WindowStart time.Time `json:"windowStart"`
+	WindowEnd   time.Time `json:"windowEnd"`
+	Key
+	Usage
+}
+
+// Sink receives the token usage records of the closed time windows.
+type Sink interface {
+	// Write delivers the records. The records are delivered again later if it returns an error.
+	Write(ctx context.Context, records []Record) error
+}
+
+// Meter aggregates the token usage of the requests over time windows, and flushes the records of
+// each closed window to a sink.
+//
+// The records of a closed window are spooled to a file before they are flushed, and the file is
+// only removed once the sink accepted them, so that they are delivered at least once even if the
+// sink fails or the gateway restarts. The usage of the open window is lost if the gateway crashes,
+// but is flushed on a graceful shutdown by Close.
+type Meter struct {
+	sink     Sink
+	window   time.Duration
+	spoolDir string
+	// instance identifies the gateway replica, so that the records of the replicas never collide.
+	instance string
+	now      func() time.Time
+
+	mu          sync.Mutex
+	windowStart time.Time
+	usage       map[Key]*Usage
+	// flushMu serializes the flushes of the spooled windows.
+	flushMu sync.Mutex
+}
+
+// NewMeter returns a meter aggregating the token usage over windows of the given duration, and
+// spooling them to spoolDir, which is created if needed, until the sink accepts them. Windows
+// spooled before a restart are flushed by the next flush. The instance, such as the pod name,
+// identifies the gateway replica in the records and spool files, and must be unique among the
+// replicas.
+func NewMeter(sink Sink, window time.Duration, spoolDir, instance string) (*Meter, error) {
+	if window <= 0 {
+		return nil, fmt.Errorf("metering window %v is not positive", window)
+	}
+	if instance == "" || strings.ContainsAny(instance, `/\`) {
+		return nil, fmt.Errorf("invalid metering instance %q", instance)
+	}
+	if err := os.MkdirAll(spoolDir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating metering spool directory: %w", err)
+	}
+	m := &Meter{
+		sink:     sink,
+		window:   window,
+		spoolDir: spoolDir,
+		instance: instance,
+		now:      time.Now,
+		usage:    make(map[Key]*Usage),
+	}
+	m.windowStart = m.now().Truncate(window)
+	return m, nil
+}
+
+// Init starts flushing the closed windows in the background.
+func (m *Meter) Init() {
+	go func() {
+		for {
+			next := m.now().Truncate(m.window).Add(m.window)
+			time.Sleep(time.Until(next))
+			m.flush(false)
+		}
+	}()
+}
+
+// Close closes the open window and flushes it, e.g. before the gateway shuts down.
+func (m *Meter) Close() {
+	m.flush(true)
+}
+
+// RecordUsage records the tokens used by a request for the target model of the model, sent by the
+// tenant.
+func (m *Meter) RecordUsage(model, targetModel, tenant string, promptTokens, completionTokens int) {
+	requestsTotal.WithLabelValues(model, targetModel).Inc()
+	promptTokensTotal.WithLabelValues(model, targetModel).Add(float64(promptTokens))
+	completionTokensTotal.WithLabelValues(model, targetModel).Add(float64(completionTokens))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := Key{Model: model, TargetModel: targetModel, Tenant: tenant}
+	u, ok := m.usage[key]
+	if !ok {
+		u = &Usage{}
+		m.usage[key] = u
+	}
+	u.Requests++
+	u.PromptTokens += int64(promptTokens)
+	u.CompletionTokens += int64(completionTokens)
+}
+
+// flush spools the open window if it is over, or if closeWindow is set, and flushes the spooled
+// windows to the sink.
+func (m *Meter) flush(closeWindow bool) {
+	if err := m.spoolWindow(closeWindow); err != nil {
+		klog.Errorf("Failed to spool token usage: %v", err)
+	}
+	if err := m.flushSpooled(); err != nil {
+		klog.Errorf("Failed to flush token usage, will retry: %v", err)
+	}
+}
+
+// spoolWindow closes the open window and writes its records to a spool file if the window is over,
+// or if force is set.
+func (m *Meter) spoolWindow(force bool) error {
+	m.mu.Lock()
+	now := m.now()
+	end := m.windowStart.Add(m.window)
+	if !force && now.Before(end) {
+		m.mu.Unlock()
+		return nil
+	}
+	start, usage := m.windowStart, m.usage
+	if now.Before(end) {
+		// The window is closed early, and the next one starts now so that their records differ.
+		end = now
+		m.windowStart = now
+	} else {
+		m.windowStart = now.Truncate(m.window)
+	}
+	m.usage = make(map[Key]*Usage)
+	m.mu.Unlock()
+
+	if len(usage) == 0 {
+		return nil
+	}
+	records := make([]Record, 0, len(usage))
+	for key, u := range usage {
+		records = append(records, Record{
+			ID:          fmt.Sprintf("%s/%d/%s/%s/%s", m.instance, start.UnixNano(), key.Model, key.TargetModel, key.Tenant),
+			WindowStart: start,
+			WindowEnd:   end,
+			Key:         key,
+			Usage:       *u,
+		})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(m.spoolDir, fmt.Sprintf("%020d-%s%s", start.UnixNano(), m.instance, spoolFileSuffix))
+	return writeFileDurably(name, data)
+}
+
+// writeFileDurably writes the file through a temporary file, so that a crash never leaves a partial
+// file behind, and syncs the file and its directory, so that the file survives a crash once
+// written.
+func writeFileDurably(name string, data []byte) error {
+	tmp := name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(name))
+	if err != nil {
+		return err
+	}
+	if err := dir.Sync(); err != nil {
+		dir.Close()
+		return err
+	}
+	return dir.Close()
+}
+
+// flushSpooled writes the spooled windows to the sink, oldest first, and removes them once the sink
+// accepted them.
+func (m *Meter) flushSpooled() error {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+	entries, err := os.ReadDir(m.spoolDir)
+	if err != nil {
+		return err
+	}
+	// The spool files are named by the start of their window, so they are sorted oldest first.
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), spoolFileSuffix) {
+			continue
+		}
+		name := filepath.Join(m.spoolDir, entry.Name())
+		records, err := readSpoolFile(name)
+		if err != nil {
+			// The file would never be flushed, and would block the next ones.
+			klog.Errorf("Failed to read spool file %q, moving it to %q: %v", name, name+quarantineSuffix, err)
+			if err := os.Rename(name, name+quarantineSuffix); err != nil {
+				klog.Errorf("Failed to move spool file %q: %v", name, err)
+			}
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), sinkTimeout)
+		err = m.sink.Write(ctx, records)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+		klog.V(3).Infof("Flushed %d token usage records from %q", len(records), name)
+	}
+	return nil
+}
+
+// readSpoolFile returns the records of the spool file.
+func readSpoolFile(name string) ([]Record, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
@@ -0,0 +1,168 @@
+package metering
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeSink struct {
+	err     error
+	records []Record
+}
+
+func (s *fakeSink) Write(_ context.Context, records []Record) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func newTestMeter(t *testing.T, sink Sink, spoolDir, instance string, now *time.Time) *Meter {
+	m, err := NewMeter(sink, time.Minute, spoolDir, instance)
+	if err != nil {
+		t.Fatalf("NewMeter: %v", err)
+	}
+	m.now = func() time.Time { return *now }
+	m.windowStart = now.Truncate(time.Minute)
+	return m
+}
+
+func TestMeter(t *testing.T) {
+	start := time.Date(2024, 11, 25, 19, 42, 0, 0, time.UTC)
+	now := start.Add(10 * time.Second)
+	spoolDir := t.TempDir()
+	sink := &fakeSink{err: errors.New("sink unavailable")}
+	m := newTestMeter(t, sink, spoolDir, "replica-1", &now)
+
+	m.RecordUsage("my-model", "my-model-v1", "tenant-a", 11, 100)
+	m.RecordUsage("my-model", "my-model-v1", "tenant-a", 5, 20)
+	m.RecordUsage("my-model", "my-model-v2", "", 7, 3)
+
+	// The window is still open.
+	m.flush(false)
+	if len(sink.records) != 0 {
+		t.Fatalf("Unexpected records flushed before the end of the window: %v", sink.records)
+	}
+
+	// The window is over, but the sink fails so the records stay spooled.
+	now = start.Add(time.Minute + time.Second)
+	m.flush(false)
+	m.RecordUsage("my-model", "my-model-v1", "tenant-a", 1, 1)
+
+	// The gateway restarts, and the new meter flushes the spooled window.
+	sink = &fakeSink{}
+	m = newTestMeter(t, sink, spoolDir, "replica-1", &now)
+	m.flush(false)
+	want := []Record{
+		{
+			ID:          "replica-1/1732563720000000000/my-model/my-model-v1/tenant-a",
+			WindowStart: start,
+			WindowEnd:   start.Add(time.Minute),
+			Key:         Key{Model: "my-model", TargetModel: "my-model-v1", Tenant: "tenant-a"},
+			Usage:       Usage{Requests: 2, PromptTokens: 16, CompletionTokens: 120},
+		},
+		{
+			ID:          "replica-1/1732563720000000000/my-model/my-model-v2/",
+			WindowStart: start,
+			WindowEnd:   start.Add(time.Minute),
+			Key:         Key{Model: "my-model", TargetModel: "my-model-v2"},
+			Usage:       Usage{Requests: 1, PromptTokens: 7, CompletionTokens: 3},
+		},
+	}
+	if diff := cmp.Diff(want, sink.records); diff != "" {
+		t.Errorf("Unexpected records (-want +got): %v", diff)
+	}
+
+	// Records are not delivered twice once the sink accepted them, and Close flushes the open window.
+	sink.records = nil
+	m.RecordUsage("my-model", "my-model-v1", "", 2, 4)
+	now = start.Add(time.Minute + 30*time.Second)
+	m.Close()
+	want = []Record{
+		{
+			ID:          "replica-1/1732563780000000000/my-model/my-model-v1/",
+			WindowStart: start.Add(time.Minute),
+			WindowEnd:   now,
+			Key:         Key{Model: "my-model", TargetModel: "my-model-v1"},
+			Usage:       Usage{Requests: 1, PromptTokens: 2, CompletionTokens: 4},
+		},
+	}
+	if diff := cmp.Diff(want, sink.records); diff != "" {
+		t.Errorf("Unexpected records after Close (-want +got): %v", diff)
+	}
+}
+
+func TestMeterReplicas(t *testing.T) {
+	now := time.Date(2024, 11, 25, 19, 42, 10, 0, time.UTC)
+	spoolDir := t.TempDir()
+	sink := &fakeSink{err: errors.New("sink unavailable")}
+	// The replicas spool the same window to the same directory, e.g. a shared volume.
+	m1 := newTestMeter(t, sink, spoolDir, "replica-1", &now)
+	m2 := newTestMeter(t, sink, spoolDir, "replica-2", &now)
+	m1.RecordUsage("my-model", "my-model-v1", "", 1, 2)
+	m2.RecordUsage("my-model", "my-model-v1", "", 3, 4)
+	m1.Close()
+	m2.Close()
+
+	sink.err = nil
+	m1.flush(false)
+	var got []string
+	for _, r := range sink.records {
+		got = append(got, r.ID)
+	}
+	want := []string{
+		"replica-1/1732563720000000000/my-model/my-model-v1/",
+		"replica-2/1732563720000000000/my-model/my-model-v1/",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Unexpected record IDs (-want +got): %v", diff)
+	}
+}
+
+func TestMeterCorruptSpoolFile(t *testing.T) {
+	now := time.Date(2024, 11, 25, 19, 42, 10, 0, time.UTC)
+	spoolDir := t.TempDir()
+	// An older spool file, e.g. truncated by a disk failure.
+	corrupt := filepath.Join(spoolDir, "00000000000000000001-replica-1"+spoolFileSuffix)
+	if err := os.WriteFile(corrupt, []byte(`[{"id":`), 0o644); err != nil {
+		t.Fatalf("Failed to write spool file: %v", err)
+	}
+	sink := &fakeSink{}
+	m := newTestMeter(t, sink, spoolDir, "replica-1", &now)
+	m.RecordUsage("my-model", "my-model-v1", "", 1, 2)
+	m.Close()
+
+	var got []string
+	for _, r := range sink.records {
+		got = append(got, r.ID)
+	}
+	if diff := cmp.Diff([]string{"replica-1/1732563720000000000/my-model/my-model-v1/"}, got); diff != "" {
+		t.Errorf("Unexpected record IDs (-want +got): %v", diff)
+	}
+	entries, err := os.ReadDir(spoolDir)
+	if err != nil {
+		t.Fatalf("Failed to read spool directory: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if diff := cmp.Diff([]string{filepath.Base(corrupt) + quarantineSuffix}, names); diff != "" {
+		t.Errorf("Unexpected spool files (-want +got): %v", diff)
+	}
+}
+
+func TestNewMeterInvalidInstance(t *testing.T) {
+	for _, instance := range []string{"", "a/b"} {
+		if _, err := NewMeter(&fakeSink{}, time.Minute, t.TempDir(), instance); err == nil {
+			t.Errorf("NewMeter(%q) succeeded", instance)
+		}
+	}
+}
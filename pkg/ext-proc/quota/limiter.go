@@ -0,0 +1,103 @@
+// Package quota enforces the token quotas of the InferenceModels.
+package quota
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	klog "k8s.io/klog/v2"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+)
+
+// Limiter rejects the requests for models whose token quota is exhausted.
+//
+// The tokens a request will use are not known until its response, so an estimate is reserved when
+// the request is admitted, and replaced by the actual usage once known. This accounts for the
+// requests in flight, so that a burst of requests cannot overrun the quota before their responses.
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{store: store, now: time.Now}
+}
+
+// Reservation is the tokens reserved for a request in the quota of its model.
+type Reservation struct {
+	store  Store
+	key    string
+	expiry time.Time
+	tokens int64
+	done   bool
+}
+
+// Reserve reserves the estimated tokens of a request for the model, sent by the tenant, and returns
+// a ResourceExhausted error if the quota of the model is already exhausted. It returns a nil
+// reservation if the model has no quota.
+//
+// Requests are admitted if the store fails, so that an unavailable store does not fail all the
+// requests.
+func (l *Limiter) Reserve(ctx context.Context, model *v1alpha1.InferenceModel, tenant string, estimate int64) (*Reservation, error) {
+	q := model.Spec.Quota
+	if q == nil {
+		return nil, nil
+	}
+	start, end := period(q.Period, l.now())
+	key := fmt.Sprintf("%s/%s/%d", model.Namespace, model.Name, start.Unix())
+	if q.PerTenant {
+		key = fmt.Sprintf("%s/%s/%s/%d", model.Namespace, model.Name, tenant, start.Unix())
+	}
+	used, err := l.store.Add(ctx, key, estimate, end)
+	if err != nil {
+		klog.Errorf("Failed to reserve %d tokens in quota %q, admitting the request: %v", estimate, key, err)
+		return nil, nil
+	}
+	r := &Reservation{store: l.store, key: key, expiry: end, tokens: estimate}
+	if used-estimate >= q.Tokens {
+		r.Release(ctx)
+		return nil, status.Errorf(codes.ResourceExhausted, "quota of %d tokens per %s exhausted for model %q, retry after %v",
+			q.Tokens, q.Period, model.Spec.ModelName, end.UTC().Format(time.RFC3339))
+	}
+	return r, nil
+}
+
+// Commit replaces the reserved tokens by the tokens actually used by the request. It is a no-op on a
+// nil, committed or released reservation.
+func (r *Reservation) Commit(ctx context.Context, tokens int64) {
+	r.settle(ctx, tokens)
+}
+
+// Release returns the reserved tokens to the quota, e.g. if the request failed. It is a no-op on a
+// nil, committed or released reservation.
+func (r *Reservation) Release(ctx context.Context) {
+	r.settle(ctx, 0)
+}
+
+func (r *Reservation) settle(ctx context.Context, tokens int64) {
+	if r == nil || r.done {
+		return
+	}
+	r.done = true
+	if tokens == r.tokens {
+		return
+	}
+	if _, err := r.store.Add(ctx, r.key, tokens-r.tokens, r.expiry); err != nil {
+		klog.Errorf("Failed to settle the tokens of quota %q: %v", r.key, err)
+	}
+}
+
+// period returns the start and the end of the quota period at the time.
+func period(p v1alpha1.QuotaPeriod, now time.Time) (time.Time, time.Time) {
+	d := time.Hour
+	if p == v1alpha1.QuotaPeriodDay {
+		d = 24 * time.Hour
+	}
+	// Truncating to 24h aligns on midnight UTC, since the zero time is at midnight UTC.
+	start := now.UTC().Truncate(d)
+	return start, start.Add(d)
+}
@@ -0,0 +1,128 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+)
+
+type failingStore struct{}
+
+func (failingStore) Add(context.Context, string, int64, time.Time) (int64, error) {
+	return 0, errors.New("store unavailable")
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 11, 25, 19, 42, 0, 0, time.UTC)
+	model := func(q *v1alpha1.TokenQuota) *v1alpha1.InferenceModel {
+		return &v1alpha1.InferenceModel{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-model", Namespace: "default"},
+			Spec:       v1alpha1.InferenceModelSpec{ModelName: "my-model", Quota: q},
+		}
+	}
+	type reserve struct {
+		tenant   string
+		estimate int64
+		// commit is the tokens committed after the reservation, or -1 to release it.
+		commit   int64
+		advance  time.Duration
+		wantCode codes.Code
+	}
+	tests := []struct {
+		name     string
+		quota    *v1alpha1.TokenQuota
+		store    Store
+		reserves []reserve
+	}{
+		{
+			name:  "no quota",
+			store: NewMemoryStore(),
+			reserves: []reserve{
+				{estimate: 1000, commit: 1000},
+				{estimate: 1000, commit: 1000},
+			},
+		},
+		{
+			name:  "exhausted quota",
+			quota: &v1alpha1.TokenQuota{Tokens: 100, Period: v1alpha1.QuotaPeriodHour},
+			store: NewMemoryStore(),
+			reserves: []reserve{
+				{estimate: 50, commit: 60},
+				{estimate: 50, commit: 40},
+				{estimate: 1, commit: 1, wantCode: codes.ResourceExhausted},
+				// The quota is reset in the next period.
+				{estimate: 1, commit: 1, advance: 20 * time.Minute},
+			},
+		},
+		{
+			name:  "in flight requests count against the quota",
+			quota: &v1alpha1.TokenQuota{Tokens: 100, Period: v1alpha1.QuotaPeriodDay},
+			store: NewMemoryStore(),
+			reserves: []reserve{
+				{estimate: 150, commit: -2},
+				{estimate: 1, commit: 1, wantCode: codes.ResourceExhausted},
+			},
+		},
+		{
+			name:  "released reservations do not count against the quota",
+			quota: &v1alpha1.TokenQuota{Tokens: 100, Period: v1alpha1.QuotaPeriodDay},
+			store: NewMemoryStore(),
+			reserves: []reserve{
+				{estimate: 150, commit: -1},
+				{estimate: 1, commit: 1},
+			},
+		},
+		{
+			name:  "per tenant quota",
+			quota: &v1alpha1.TokenQuota{Tokens: 100, Period: v1alpha1.QuotaPeriodDay, PerTenant: true},
+			store: NewMemoryStore(),
+			reserves: []reserve{
+				{tenant: "a", estimate: 100, commit: 100},
+				{tenant: "a", estimate: 1, commit: 1, wantCode: codes.ResourceExhausted},
+				{tenant: "b", estimate: 1, commit: 1},
+			},
+		},
+		{
+			name:  "store failure admits requests",
+			quota: &v1alpha1.TokenQuota{Tokens: 1, Period: v1alpha1.QuotaPeriodDay},
+			store: failingStore{},
+			reserves: []reserve{
+				{estimate: 100, commit: 100},
+				{estimate: 100, commit: 100},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := now
+			l := NewLimiter(test.store)
+			l.now = func() time.Time { return current }
+			if s, ok := test.store.(*MemoryStore); ok {
+				s.now = l.now
+			}
+			for i, r := range test.reserves {
+				current = current.Add(r.advance)
+				reservation, err := l.Reserve(ctx, model(test.quota), r.tenant, r.estimate)
+				if status.Code(err) != r.wantCode {
+					t.Fatalf("Reservation %d: unexpected error, got %v, want %v", i, err, r.wantCode)
+				}
+				// commit -2 keeps the reservation in flight.
+				switch r.commit {
+				case -1:
+					reservation.Release(ctx)
+				case -2:
+				default:
+					reservation.Commit(ctx, r.commit)
+				}
+			}
+		})
+	}
+}
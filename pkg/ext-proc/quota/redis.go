@@ -0,0 +1,59 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisKeyPrefix prefixes the keys of the quotas in Redis, to share a Redis with other users.
+	redisKeyPrefix = "llm-instance-gateway:quota:"
+	// redisTimeout bounds how long a call to Redis may take, including connecting, so that an
+	// unresponsive Redis delays the requests by at most that long before they are admitted.
+	redisTimeout = time.Second
+)
+
+// redisAddScript atomically adds the tokens to the key and sets its expiry.
+var redisAddScript = redis.NewScript(`local v = redis.call('INCRBY', KEYS[1], ARGV[1])
+redis.call('EXPIREAT', KEYS[1], ARGV[2])
+return v`)
+
+// RedisStore is a Store shared by the ext-proc replicas through Redis, so that they enforce the
+// quotas together.
+type RedisStore struct {
+	client *redis.Client
+	// timeout bounds each call to Redis.
+	timeout time.Duration
+}
+
+// NewRedisStore returns a store in the Redis at the URL, of the form
+// redis://[:password@]host:port[/db], or rediss:// for TLS.
+func NewRedisStore(rawURL string) (*RedisStore, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	opts.DialTimeout = redisTimeout
+	opts.ReadTimeout = redisTimeout
+	opts.WriteTimeout = redisTimeout
+	opts.ContextTimeoutEnabled = true
+	return &RedisStore{client: redis.NewClient(opts), timeout: redisTimeout}, nil
+}
+
+func (s *RedisStore) Add(ctx context.Context, key string, tokens int64, expiry time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	v, err := redisAddScript.Run(ctx, s.client, []string{redisKeyPrefix + key}, tokens, expiry.Unix()).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("adding to Redis: %w", err)
+	}
+	return v, nil
+}
+
+// Close closes the connections to Redis.
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,102 @@
+package quota
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRedisStore(t *testing.T) {
+	ctx := context.Background()
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	m := miniredis.RunT(t)
+	m.RequireAuth("secret")
+
+	// Two replicas share the quota.
+	a, err := NewRedisStore("redis://:secret@" + m.Addr())
+	if err != nil {
+		t.Fatalf("NewRedisStore() failed: %v", err)
+	}
+	defer a.Close()
+	b, err := NewRedisStore("redis://:secret@" + m.Addr())
+	if err != nil {
+		t.Fatalf("NewRedisStore() failed: %v", err)
+	}
+	defer b.Close()
+	for i, add := range []struct {
+		store  *RedisStore
+		tokens int64
+		want   int64
+	}{
+		{store: a, tokens: 100, want: 100},
+		{store: b, tokens: 50, want: 150},
+		{store: a, tokens: -30, want: 120},
+	} {
+		got, err := add.store.Add(ctx, "key", add.tokens, expiry)
+		if err != nil {
+			t.Fatalf("Add %d failed: %v", i, err)
+		}
+		if got != add.want {
+			t.Errorf("Add %d = %d, want %d", i, got, add.want)
+		}
+	}
+	m.SetTime(time.Now())
+	if got, want := m.TTL(redisKeyPrefix+"key"), time.Until(expiry); got < want-time.Minute || got > want+time.Minute {
+		t.Errorf("TTL = %v, want about %v", got, want)
+	}
+}
+
+func TestRedisStoreErrors(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	m.RequireAuth("secret")
+	s, err := NewRedisStore("redis://:wrong@" + m.Addr())
+	if err != nil {
+		t.Fatalf("NewRedisStore() failed: %v", err)
+	}
+	defer s.Close()
+	if _, err := s.Add(ctx, "key", 1, time.Now()); err == nil {
+		t.Errorf("Add() with a wrong password succeeded")
+	}
+	for _, url := range []string{"http://" + m.Addr(), "redis://" + m.Addr() + "/db"} {
+		if _, err := NewRedisStore(url); err == nil {
+			t.Errorf("NewRedisStore(%q) succeeded", url)
+		}
+	}
+}
+
+func TestRedisStoreTimeout(t *testing.T) {
+	// A Redis which accepts connections but never replies.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	s, err := NewRedisStore("redis://" + lis.Addr().String())
+	if err != nil {
+		t.Fatalf("NewRedisStore() failed: %v", err)
+	}
+	defer s.Close()
+	s.timeout = 50 * time.Millisecond
+
+	// The context has no deadline, as the contexts of the request streams.
+	start := time.Now()
+	if _, err := s.Add(context.Background(), "key", 1, time.Now()); err == nil {
+		t.Errorf("Add() to an unresponsive Redis succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 10*s.timeout {
+		t.Errorf("Add() took %v, want at most about %v", elapsed, s.timeout)
+	}
+}
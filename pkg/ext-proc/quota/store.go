@@ -0,0 +1,52 @@
+package quota
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store keeps the number of tokens used per quota period. To keep the quotas consistent across
+// ext-proc replicas, the replicas must share the store, e.g. a RedisStore.
+type Store interface {
+	// Add atomically adds tokens, which may be negative, to the tokens used under the key, and
+	// returns the new number of tokens used. The key may be forgotten after expiry.
+	Add(ctx context.Context, key string, tokens int64, expiry time.Time) (int64, error)
+}
+
+// MemoryStore is a Store local to an ext-proc replica, e.g. for tests or single replica
+// deployments.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	now     func() time.Time
+}
+
+type memoryEntry struct {
+	tokens int64
+	expiry time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*memoryEntry), now: time.Now}
+}
+
+func (s *MemoryStore) Add(_ context.Context, key string, tokens int64, expiry time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	e, ok := s.entries[key]
+	if !ok || !now.Before(e.expiry) {
+		// Forget the expired periods when a new one starts.
+		for k, e := range s.entries {
+			if !now.Before(e.expiry) {
+				delete(s.entries, k)
+			}
+		}
+		e = &memoryEntry{}
+		s.entries[key] = e
+	}
+	e.tokens += tokens
+	e.expiry = expiry
+	return e.tokens, nil
+}
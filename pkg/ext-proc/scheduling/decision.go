@@ -0,0 +1,121 @@
+package scheduling
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	klog "k8s.io/klog/v2"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+)
+
+// Decision is the trace of a scheduling decision, explaining why a pod was picked for a request
+// or why the request was dropped.
+type Decision struct {
+	Time        time.Time            `json:"time"`
+	Model       string               `json:"model"`
+	TargetModel string               `json:"targetModel"`
+	Criticality v1alpha1.Criticality `json:"criticality,omitempty"`
+	Steps       []FilterStep         `json:"steps"`
+	// Candidates is the number of pods the target pod was picked from at random.
+	Candidates int    `json:"candidates"`
+	TargetPod  string `json:"targetPod,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// FilterStep is the outcome of applying a filter during a scheduling decision.
+type FilterStep struct {
+	Filter     string `json:"filter"`
+	InputPods  int    `json:"inputPods"`
+	OutputPods int    `json:"outputPods"`
+	// Passed is whether the filter succeeded, in which case the next filter is applied to its
+	// output pods rather than to its input pods.
+	Passed bool `json:"passed"`
+}
+
+// Path returns the names of the filters applied, in order.
+func (d *Decision) Path() []string {
+	if d == nil {
+		return nil
+	}
+	path := make([]string, 0, len(d.Steps))
+	for _, step := range d.Steps {
+		path = append(path, step.Filter)
+	}
+	return path
+}
+
+func (d *Decision) String() string {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+// DecisionLog keeps the last scheduling decisions, and serves them as JSON over HTTP, newest first.
+// The "limit" query parameter limits the number of decisions served.
+type DecisionLog struct {
+	mu        sync.Mutex
+	decisions []Decision
+	// next is the index of decisions the next decision is written to.
+	next int
+	full bool
+}
+
+// NewDecisionLog returns a DecisionLog keeping the last size decisions, or none if size is not
+// positive.
+func NewDecisionLog(size int) *DecisionLog {
+	return &DecisionLog{decisions: make([]Decision, max(size, 0))}
+}
+
+// Add records a decision, overwriting the oldest one if the log is full.
+func (l *DecisionLog) Add(d Decision) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.decisions) == 0 {
+		return
+	}
+	l.decisions[l.next] = d
+	l.next = (l.next + 1) % len(l.decisions)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Last returns up to n of the last decisions, newest first. All the decisions are returned if n
+// is not positive.
+func (l *DecisionLog) Last(n int) []Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count := l.next
+	if l.full {
+		count = len(l.decisions)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+	res := make([]Decision, 0, n)
+	for i := 1; i <= n; i++ {
+		res = append(res, l.decisions[(l.next-i+len(l.decisions))%len(l.decisions)])
+	}
+	return res
+}
+
+func (l *DecisionLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "invalid limit: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(l.Last(limit)); err != nil {
+		klog.Errorf("Failed to write scheduling decisions: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package scheduling
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+)
+
+func TestDecisionLog(t *testing.T) {
+	log := NewDecisionLog(3)
+	if got := log.Last(0); len(got) != 0 {
+		t.Errorf("Expected no decisions, got %v", got)
+	}
+	for _, pod := range []string{"pod1", "pod2", "pod3", "pod4"} {
+		log.Add(Decision{TargetPod: pod})
+	}
+	targetPods := func(decisions []Decision) []string {
+		var pods []string
+		for _, d := range decisions {
+			pods = append(pods, d.TargetPod)
+		}
+		return pods
+	}
+	if diff := cmp.Diff([]string{"pod4", "pod3", "pod2"}, targetPods(log.Last(0))); diff != "" {
+		t.Errorf("Unexpected decisions (-want +got): %v", diff)
+	}
+	if diff := cmp.Diff([]string{"pod4", "pod3"}, targetPods(log.Last(2))); diff != "" {
+		t.Errorf("Unexpected decisions (-want +got): %v", diff)
+	}
+
+	rec := httptest.NewRecorder()
+	log.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/scheduler/decisions?limit=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %v", rec.Code)
+	}
+	var served []Decision
+	if err := json.Unmarshal(rec.Body.Bytes(), &served); err != nil {
+		t.Fatalf("Failed to unmarshal decisions: %v", err)
+	}
+	if diff := cmp.Diff([]string{"pod4"}, targetPods(served)); diff != "" {
+		t.Errorf("Unexpected served decisions (-want +got): %v", diff)
+	}
+
+	rec = httptest.NewRecorder()
+	log.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/scheduler/decisions?limit=all", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected status code %v for an invalid limit", rec.Code)
+	}
+}
+
+func TestDecisionLogNegativeSize(t *testing.T) {
+	log := NewDecisionLog(-1)
+	log.Add(Decision{TargetPod: "pod1"})
+	if got := log.Last(0); len(got) != 0 {
+		t.Errorf("Expected no decisions, got %v", got)
+	}
+}
+
+func TestScheduleRecordsDecision(t *testing.T) {
+	pods := []*backend.PodMetrics{
+		{
+			Pod: backend.Pod{Name: "pod1"},
+			Metrics: backend.Metrics{
+				WaitingQueueSize:    10,
+				KVCacheUsagePercent: 0.95,
+				ActiveModels:        map[string]int{},
+			},
+		},
+	}
+	log := NewDecisionLog(10)
+	s := NewScheduler(&fakePodMetricsProvider{pods: pods}, WithDecisionLog(log))
+	req := &LLMRequest{Model: "model", ResolvedTargetModel: "adapter", Criticality: v1alpha1.Sheddable}
+	if _, err := s.Schedule(req); err == nil {
+		t.Fatalf("Expected the sheddable request to be dropped")
+	}
+	got := log.Last(0)
+	if len(got) != 1 {
+		t.Fatalf("Expected one decision, got %v", got)
+	}
+	want := Decision{
+		Model:       "model",
+		TargetModel: "adapter",
+		Criticality: v1alpha1.Sheddable,
+		Steps: []FilterStep{
+			{Filter: "critical request", InputPods: 1, OutputPods: 0},
+			{Filter: "default request", InputPods: 1, OutputPods: 0},
+			{Filter: "has capacity for sheddable requests", InputPods: 1, OutputPods: 0},
+			{Filter: "drop request", InputPods: 1, OutputPods: 0},
+		},
+		Error: req.Decision.Error,
+	}
+	if diff := cmp.Diff(want, got[0], cmpopts.IgnoreFields(Decision{}, "Time")); diff != "" {
+		t.Errorf("Unexpected decision (-want +got): %v", diff)
+	}
+	if got[0].Error == "" {
+		t.Errorf("Expected the decision to record the error")
+	}
+}
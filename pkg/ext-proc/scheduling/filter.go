@@ -6,6 +6,7 @@ import (
 
 	klog "k8s.io/klog/v2"
 
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
 	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
 )
 
@@ -45,6 +46,14 @@ func (f *filter) Filter(req *LLMRequest, pods []*backend.PodMetrics) ([]*backend
 	klog.V(3).Infof("Running filter %q on request %v with %v pods", f.name, req, len(pods))
 
 	filtered, err := f.filter(req, pods)
+	if req != nil && req.Decision != nil {
+		req.Decision.Steps = append(req.Decision.Steps, FilterStep{
+			Filter:     f.name,
+			InputPods:  len(pods),
+			OutputPods: len(filtered),
+			Passed:     err == nil && len(filtered) > 0,
+		})
+	}
 
 	next := f.nextOnSuccessOrFailure
 	if err == nil && len(filtered) > 0 {
@@ -177,11 +186,35 @@ func canAcceptNewLoraPredicate(req *LLMRequest, pod *backend.PodMetrics) bool {
 }
 
 func criticalRequestPredicate(req *LLMRequest, pod *backend.PodMetrics) bool {
-	return req.Critical
+	return req.Criticality == v1alpha1.Critical
+}
+
+func defaultRequestPredicate(req *LLMRequest, pod *backend.PodMetrics) bool {
+	return req.Criticality == v1alpha1.Default
 }
 
 func noQueueAndLessThanKVCacheThresholdPredicate(queueThreshold int, kvCacheThreshold float64) podPredicate {
 	return func(req *LLMRequest, pod *backend.PodMetrics) bool {
-		return pod.WaitingQueueSize <= queueThreshold && pod.KVCacheUsagePercent <= kvCacheThreshold
+		return pod.WaitingQueueSize <= queueThreshold && projectedKVCacheUsage(pod) <= kvCacheThreshold
+	}
+}
+
+// hasCapacityPredicate checks whether a pod has capacity for a default or sheddable request: it is
+// under the queue and KV cache thresholds, and can serve the target model of the request without
+// evicting an adapter.
+func hasCapacityPredicate(queueThreshold int, kvCacheThreshold float64) podPredicate {
+	underThresholds := noQueueAndLessThanKVCacheThresholdPredicate(queueThreshold, kvCacheThreshold)
+	return func(req *LLMRequest, pod *backend.PodMetrics) bool {
+		return underThresholds(req, pod) && (!req.adapter || lowLoRACostPredicate(req, pod))
+	}
+}
+
+// projectedKVCacheUsage returns the KV cache usage of the pod projected kvCacheTrendHorizon ahead
+// from its trend if it is growing, so that pods whose KV cache is filling up fast are avoided before
+// they reach the thresholds.
+func projectedKVCacheUsage(pod *backend.PodMetrics) float64 {
+	if pod.Trend.KVCacheUsageSlope <= 0 {
+		return pod.KVCacheUsagePercent
 	}
+	return pod.KVCacheUsagePercent + pod.Trend.KVCacheUsageSlope*kvCacheTrendHorizon.Seconds()
 }
@@ -6,6 +6,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
 	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
 )
 
@@ -31,7 +32,7 @@ func TestFilter(t *testing.T) {
 			req: &LLMRequest{
 				Model:               "critical",
 				ResolvedTargetModel: "critical",
-				Critical:            true,
+				Criticality:         v1alpha1.Critical,
 			},
 			// pod2 will be picked because it has relatively low queue size, with the requested
 			// model being active, and has low KV cache.
@@ -93,7 +94,7 @@ func TestFilter(t *testing.T) {
 			req: &LLMRequest{
 				Model:               "sheddable",
 				ResolvedTargetModel: "sheddable",
-				Critical:            false,
+				Criticality:         v1alpha1.Sheddable,
 			},
 			// pod1 will be picked because it has capacity for the sheddable request.
 			input: []*backend.PodMetrics{
@@ -154,7 +155,7 @@ func TestFilter(t *testing.T) {
 			req: &LLMRequest{
 				Model:               "sheddable",
 				ResolvedTargetModel: "sheddable",
-				Critical:            false,
+				Criticality:         v1alpha1.Sheddable,
 			},
 			// All pods have higher KV cache thant the threshold, so the sheddable request will be
 			// dropped.
@@ -198,6 +199,106 @@ func TestFilter(t *testing.T) {
 			output: []*backend.PodMetrics{},
 			err:    true,
 		},
+		{
+			name:   "default filter, default request, accepted",
+			filter: defaultFilter,
+			req: &LLMRequest{
+				Model:               "default",
+				ResolvedTargetModel: "default",
+				Criticality:         v1alpha1.Default,
+			},
+			// The pods are above the sheddable thresholds but below the looser thresholds for default
+			// requests. pod2 will be picked because it has the lowest queue size.
+			input: []*backend.PodMetrics{
+				{
+					Pod: backend.Pod{Name: "pod1"},
+					Metrics: backend.Metrics{
+						WaitingQueueSize:    10,
+						KVCacheUsagePercent: 0.9,
+						MaxActiveModels:     2,
+						ActiveModels: map[string]int{
+							"foo": 1,
+							"bar": 1,
+						},
+					},
+				},
+				{
+					Pod: backend.Pod{Name: "pod2"},
+					Metrics: backend.Metrics{
+						WaitingQueueSize:    3,
+						KVCacheUsagePercent: 0.85,
+						MaxActiveModels:     2,
+						ActiveModels: map[string]int{
+							"foo":      1,
+							"critical": 1,
+						},
+					},
+				},
+				{
+					Pod: backend.Pod{Name: "pod3"},
+					Metrics: backend.Metrics{
+						WaitingQueueSize:    10,
+						KVCacheUsagePercent: 0.85,
+						MaxActiveModels:     2,
+						ActiveModels: map[string]int{
+							"foo": 1,
+						},
+					},
+				},
+			},
+			output: []*backend.PodMetrics{
+				{
+					Pod: backend.Pod{Name: "pod2"},
+					Metrics: backend.Metrics{
+						WaitingQueueSize:    3,
+						KVCacheUsagePercent: 0.85,
+						MaxActiveModels:     2,
+						ActiveModels: map[string]int{
+							"foo":      1,
+							"critical": 1,
+						},
+					},
+				},
+			},
+		},
+		{
+			name:   "default filter, default request, dropped",
+			filter: defaultFilter,
+			req: &LLMRequest{
+				Model:               "default",
+				ResolvedTargetModel: "default",
+				Criticality:         v1alpha1.Default,
+			},
+			// All pods are above the thresholds for default requests, so the request will be dropped.
+			input: []*backend.PodMetrics{
+				{
+					Pod: backend.Pod{Name: "pod1"},
+					Metrics: backend.Metrics{
+						WaitingQueueSize:    30,
+						KVCacheUsagePercent: 0.95,
+						MaxActiveModels:     2,
+						ActiveModels: map[string]int{
+							"foo": 1,
+							"bar": 1,
+						},
+					},
+				},
+				{
+					Pod: backend.Pod{Name: "pod2"},
+					Metrics: backend.Metrics{
+						WaitingQueueSize:    25,
+						KVCacheUsagePercent: 0.8,
+						MaxActiveModels:     2,
+						ActiveModels: map[string]int{
+							"foo":      1,
+							"critical": 1,
+						},
+					},
+				},
+			},
+			output: []*backend.PodMetrics{},
+			err:    true,
+		},
 	}
 
 	for _, test := range tests {
@@ -214,6 +315,48 @@ func TestFilter(t *testing.T) {
 	}
 }
 
+func TestFilterDecisionSteps(t *testing.T) {
+	pods := []*backend.PodMetrics{
+		{
+			Pod: backend.Pod{Name: "pod1"},
+			Metrics: backend.Metrics{
+				WaitingQueueSize:    10,
+				KVCacheUsagePercent: 0.85,
+				MaxActiveModels:     2,
+				ActiveModels:        map[string]int{},
+			},
+		},
+	}
+	tests := []struct {
+		name        string
+		criticality v1alpha1.Criticality
+		want        []string
+	}{
+		{
+			name:        "sheddable request dropped",
+			criticality: v1alpha1.Sheddable,
+			want:        []string{"critical request", "default request", "has capacity for sheddable requests", "drop request"},
+		},
+		{
+			name:        "default request",
+			criticality: v1alpha1.Default,
+			want: []string{
+				"critical request", "default request", "has capacity for default requests",
+				"least queuing", "low cost LoRA", "least KV cache percent",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := &LLMRequest{Model: "model", ResolvedTargetModel: "model", Criticality: test.criticality, Decision: &Decision{}}
+			_, _ = defaultFilter.Filter(req, pods)
+			if diff := cmp.Diff(test.want, req.Decision.Path()); diff != "" {
+				t.Errorf("Unexpected scheduling path (-want +got): %v", diff)
+			}
+		})
+	}
+}
+
 func TestFilterFunc(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -326,6 +469,22 @@ func TestFilterFunc(t *testing.T) {
 						KVCacheUsagePercent: 1.0,
 					},
 				},
+				{
+					// KV cache under the threshold but climbing fast, should not return
+					Metrics: backend.Metrics{
+						WaitingQueueSize:    0,
+						KVCacheUsagePercent: 0.6,
+						Trend:               backend.Trend{KVCacheUsageSlope: 0.2},
+					},
+				},
+				{
+					// KV cache under the threshold and decreasing, should return
+					Metrics: backend.Metrics{
+						WaitingQueueSize:    0,
+						KVCacheUsagePercent: 0.7,
+						Trend:               backend.Trend{KVCacheUsageSlope: -0.2},
+					},
+				},
 			},
 			output: []*backend.PodMetrics{
 				{
@@ -334,6 +493,13 @@ func TestFilterFunc(t *testing.T) {
 						KVCacheUsagePercent: 0,
 					},
 				},
+				{
+					Metrics: backend.Metrics{
+						WaitingQueueSize:    0,
+						KVCacheUsagePercent: 0.7,
+						Trend:               backend.Trend{KVCacheUsageSlope: -0.2},
+					},
+				},
 			},
 		},
 		{
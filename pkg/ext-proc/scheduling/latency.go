@@ -0,0 +1,207 @@
+package scheduling
+
+import (
+	"sync"
+	"time"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+)
+
+// The features of a request on a pod the latency of the request is predicted from.
+const (
+	featureBias = iota
+	featureWaitingQueueSize
+	featureRunningQueueSize
+	featureKVCacheUsage
+	// featurePromptTokens is the number of prompt tokens, in thousands.
+	featurePromptTokens
+	// featureLoRALoadCost is 1 if the target model has to be loaded on the pod, 0 otherwise.
+	featureLoRALoadCost
+	numFeatures
+)
+
+const (
+	// defaultOutputTokens is the number of output tokens expected for a request without max_tokens.
+	defaultOutputTokens = 256
+	// forgettingFactor is the weight of the past observations relative to the next one, so that the
+	// model follows the changes of the latency of the model servers.
+	forgettingFactor = 0.995
+	// initialCovariance is the initial uncertainty of the coefficients. The higher, the faster the
+	// coefficients move away from their initial values.
+	initialCovariance = 100
+	// maxCovarianceTrace stops the uncertainty from growing without bounds while the observations
+	// do not vary, e.g. under a constant load.
+	maxCovarianceTrace = 1e6
+	// recentPickWindow is how long the requests sent to a pod are counted as waiting on the pod, in
+	// addition to its waiting queue, so that a burst of requests sent before the metrics of the pods
+	// are refreshed are not all sent to the same pod.
+	recentPickWindow = 200 * time.Millisecond
+)
+
+// LatencyFeatures is the features of a request on the pod it was sent to, at the time it was
+// scheduled.
+type LatencyFeatures [numFeatures]float64
+
+// LatencyPredictor predicts the time to first token and the time per output token of a request on
+// each pod with linear models of the features of the request on the pod, and learns the
+// coefficients of the models online from the observed latencies of the requests.
+//
+// The time to first token and the time per output token of a request are not observed separately,
+// so their coefficients are learned together by recursive least squares on the latency of the
+// requests, which is the time to first token plus the time per output token times the number of
+// output tokens.
+type LatencyPredictor struct {
+	mu sync.Mutex
+	// coefficients are the coefficients of the time to first token for the features, followed by
+	// the coefficients of the time per output token, in seconds.
+	coefficients [2 * numFeatures]float64
+	covariance   [2 * numFeatures][2 * numFeatures]float64
+	// recentPicks is when requests were recently sent to each pod.
+	recentPicks map[backend.Pod][]time.Time
+	now         func() time.Time
+}
+
+// NewLatencyPredictor returns a predictor whose coefficients start from rough estimates, before any
+// latency is observed.
+func NewLatencyPredictor() *LatencyPredictor {
+	p := &LatencyPredictor{
+		recentPicks: make(map[backend.Pod][]time.Time),
+		now:         time.Now,
+	}
+	ttft := p.coefficients[:numFeatures]
+	ttft[featureBias] = 0.05
+	ttft[featureWaitingQueueSize] = 0.2
+	ttft[featureRunningQueueSize] = 0.01
+	ttft[featureKVCacheUsage] = 0.1
+	ttft[featurePromptTokens] = 0.05
+	ttft[featureLoRALoadCost] = 0.5
+	tpot := p.coefficients[numFeatures:]
+	tpot[featureBias] = 0.02
+	tpot[featureRunningQueueSize] = 0.001
+	tpot[featureKVCacheUsage] = 0.01
+	for i := range p.covariance {
+		p.covariance[i][i] = initialCovariance
+	}
+	return p
+}
+
+// LatencyPrediction is the predicted latency of a request on the pod it was sent to.
+type LatencyPrediction struct {
+	Features         LatencyFeatures
+	TimeToFirstToken time.Duration
+	TimePerToken     time.Duration
+}
+
+// features returns the features of the request on the pod.
+func (p *LatencyPredictor) features(req *LLMRequest, pod *backend.PodMetrics, now time.Time) LatencyFeatures {
+	var x LatencyFeatures
+	x[featureBias] = 1
+	x[featureWaitingQueueSize] = float64(pod.WaitingQueueSize + p.recentPickCount(pod.Pod, now))
+	x[featureRunningQueueSize] = float64(pod.RunningQueueSize)
+	x[featureKVCacheUsage] = pod.KVCacheUsagePercent
+	x[featurePromptTokens] = float64(req.PromptTokens) / 1000
+	if _, ok := pod.ActiveModels[req.ResolvedTargetModel]; !ok && pod.MaxActiveModels > 0 {
+		x[featureLoRALoadCost] = 1
+	}
+	return x
+}
+
+// recentPickCount returns the number of requests sent to the pod within the recent pick window,
+// and forgets the older ones. p.mu must be held.
+func (p *LatencyPredictor) recentPickCount(pod backend.Pod, now time.Time) int {
+	picks := p.recentPicks[pod]
+	i := 0
+	for i < len(picks) && now.Sub(picks[i]) > recentPickWindow {
+		i++
+	}
+	if i == len(picks) {
+		delete(p.recentPicks, pod)
+		return 0
+	}
+	p.recentPicks[pod] = picks[i:]
+	return len(picks) - i
+}
+
+// pick returns the pod with the lowest predicted latency for the request, and its prediction.
+func (p *LatencyPredictor) pick(req *LLMRequest, pods []*backend.PodMetrics) (*backend.PodMetrics, *LatencyPrediction) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	outputTokens := float64(defaultOutputTokens)
+	if req.MaxTokens > 0 {
+		outputTokens = float64(req.MaxTokens)
+	}
+	var best *backend.PodMetrics
+	var bestPrediction *LatencyPrediction
+	var bestLatency float64
+	for _, pod := range pods {
+		x := p.features(req, pod, now)
+		ttft, tpot := p.predict(x)
+		if latency := ttft + tpot*outputTokens; best == nil || latency < bestLatency {
+			best, bestLatency = pod, latency
+			bestPrediction = &LatencyPrediction{
+				Features:         x,
+				TimeToFirstToken: seconds(ttft),
+				TimePerToken:     seconds(tpot),
+			}
+		}
+	}
+	if best != nil {
+		p.recentPicks[best.Pod] = append(p.recentPicks[best.Pod], now)
+	}
+	return best, bestPrediction
+}
+
+// predict returns the predicted time to first token and time per output token, in seconds, of the
+// features. p.mu must be held.
+func (p *LatencyPredictor) predict(x LatencyFeatures) (float64, float64) {
+	var ttft, tpot float64
+	for i, v := range x {
+		ttft += p.coefficients[i] * v
+		tpot += p.coefficients[numFeatures+i] * v
+	}
+	return max(ttft, 0), max(tpot, 0)
+}
+
+// ObserveLatency updates the coefficients from the latency of a request which generated
+// outputTokens tokens, with the features it was scheduled with.
+func (p *LatencyPredictor) ObserveLatency(x LatencyFeatures, latency time.Duration, outputTokens int) {
+	var z [2 * numFeatures]float64
+	for i, v := range x {
+		z[i] = v
+		z[numFeatures+i] = v * float64(outputTokens)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Recursive least squares update with exponential forgetting.
+	var pz [2 * numFeatures]float64
+	var zpz, predicted float64
+	for i := range z {
+		for j := range z {
+			pz[i] += p.covariance[i][j] * z[j]
+		}
+		zpz += z[i] * pz[i]
+		predicted += p.coefficients[i] * z[i]
+	}
+	residual := latency.Seconds() - predicted
+	denominator := forgettingFactor + zpz
+	var trace float64
+	for i := range z {
+		p.coefficients[i] += pz[i] / denominator * residual
+		for j := range z {
+			p.covariance[i][j] -= pz[i] * pz[j] / denominator
+		}
+		trace += p.covariance[i][i]
+	}
+	if trace < maxCovarianceTrace {
+		for i := range z {
+			for j := range z {
+				p.covariance[i][j] /= forgettingFactor
+			}
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
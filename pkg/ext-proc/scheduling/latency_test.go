@@ -0,0 +1,176 @@
+package scheduling
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+)
+
+func TestLatencyPredictorLearns(t *testing.T) {
+	// The actual coefficients of the model servers, in seconds, differ from the initial ones.
+	var ttft, tpot LatencyFeatures
+	ttft[featureBias] = 0.1
+	ttft[featureWaitingQueueSize] = 0.5
+	ttft[featurePromptTokens] = 0.2
+	ttft[featureLoRALoadCost] = 1
+	tpot[featureBias] = 0.03
+	tpot[featureRunningQueueSize] = 0.002
+	tpot[featureKVCacheUsage] = 0.02
+
+	r := rand.New(rand.NewSource(1))
+	randomFeatures := func() LatencyFeatures {
+		var x LatencyFeatures
+		x[featureBias] = 1
+		x[featureWaitingQueueSize] = float64(r.Intn(10))
+		x[featureRunningQueueSize] = float64(r.Intn(50))
+		x[featureKVCacheUsage] = r.Float64()
+		x[featurePromptTokens] = r.Float64() * 4
+		x[featureLoRALoadCost] = float64(r.Intn(2))
+		return x
+	}
+	dot := func(a, b LatencyFeatures) float64 {
+		var sum float64
+		for i := range a {
+			sum += a[i] * b[i]
+		}
+		return sum
+	}
+
+	p := NewLatencyPredictor()
+	for i := 0; i < 500; i++ {
+		x := randomFeatures()
+		outputTokens := 1 + r.Intn(500)
+		p.ObserveLatency(x, seconds(dot(ttft, x)+dot(tpot, x)*float64(outputTokens)), outputTokens)
+	}
+	for i := 0; i < 10; i++ {
+		x := randomFeatures()
+		gotTTFT, gotTPOT := p.predict(x)
+		if wantTTFT := dot(ttft, x); math.Abs(gotTTFT-wantTTFT) > 0.01*wantTTFT {
+			t.Errorf("Unexpected time to first token for %v, got %v, want %v", x, gotTTFT, wantTTFT)
+		}
+		if wantTPOT := dot(tpot, x); math.Abs(gotTPOT-wantTPOT) > 0.01*wantTPOT {
+			t.Errorf("Unexpected time per token for %v, got %v, want %v", x, gotTPOT, wantTPOT)
+		}
+	}
+}
+
+func TestScheduleWithLatencyPredictor(t *testing.T) {
+	queuing := &backend.PodMetrics{
+		Pod: backend.Pod{Name: "queuing"},
+		Metrics: backend.Metrics{
+			WaitingQueueSize: 3,
+			MaxActiveModels:  4,
+			ActiveModels:     map[string]int{"lora": 1},
+		},
+	}
+	idle := &backend.PodMetrics{
+		Pod: backend.Pod{Name: "idle"},
+		Metrics: backend.Metrics{
+			MaxActiveModels: 4,
+			ActiveModels:    map[string]int{"lora": 1},
+		},
+	}
+	idleWithoutLoRA := &backend.PodMetrics{
+		Pod: backend.Pod{Name: "idle without LoRA"},
+		Metrics: backend.Metrics{
+			MaxActiveModels: 4,
+			ActiveModels:    map[string]int{},
+		},
+	}
+	full := &backend.PodMetrics{
+		Pod: backend.Pod{Name: "full"},
+		Metrics: backend.Metrics{
+			WaitingQueueSize:    10,
+			KVCacheUsagePercent: 0.95,
+			MaxActiveModels:     4,
+			ActiveModels:        map[string]int{"lora": 1},
+		},
+	}
+	tests := []struct {
+		name        string
+		criticality v1alpha1.Criticality
+		pods        []*backend.PodMetrics
+		want        backend.Pod
+		wantCode    codes.Code
+	}{
+		{
+			name:        "least queuing",
+			criticality: v1alpha1.Critical,
+			pods:        []*backend.PodMetrics{queuing, idle},
+			want:        idle.Pod,
+		},
+		{
+			name:        "LoRA loaded",
+			criticality: v1alpha1.Critical,
+			pods:        []*backend.PodMetrics{idleWithoutLoRA, idle},
+			want:        idle.Pod,
+		},
+		{
+			name:        "sheddable request without capacity",
+			criticality: v1alpha1.Sheddable,
+			pods:        []*backend.PodMetrics{full},
+			wantCode:    codes.ResourceExhausted,
+		},
+		{
+			name:        "critical request without capacity",
+			criticality: v1alpha1.Critical,
+			pods:        []*backend.PodMetrics{full},
+			want:        full.Pod,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewScheduler(&fakePodMetricsProvider{pods: test.pods}, WithLatencyPredictor(NewLatencyPredictor()))
+			req := &LLMRequest{
+				Model:               "model",
+				ResolvedTargetModel: "lora",
+				Criticality:         test.criticality,
+				PromptTokens:        100,
+			}
+			got, err := s.Schedule(req)
+			if status.Code(err) != test.wantCode {
+				t.Fatalf("Unexpected error, got %v, want code %v", err, test.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if got != test.want {
+				t.Errorf("Unexpected pod, got %v, want %v", got, test.want)
+			}
+			if req.Prediction == nil || req.Prediction.TimeToFirstToken <= 0 {
+				t.Errorf("Unexpected prediction %+v", req.Prediction)
+			}
+			if path := req.Decision.Path(); path[len(path)-1] != lowestPredictedLatencyPath {
+				t.Errorf("Unexpected scheduling path %v", path)
+			}
+		})
+	}
+}
+
+func TestLatencyPredictorSpreadsBursts(t *testing.T) {
+	pods := []*backend.PodMetrics{
+		{Pod: backend.Pod{Name: "pod1"}},
+		{Pod: backend.Pod{Name: "pod2"}},
+	}
+	now := time.Now()
+	p := NewLatencyPredictor()
+	p.now = func() time.Time { return now }
+	req := &LLMRequest{Model: "model", ResolvedTargetModel: "model"}
+	first, _ := p.pick(req, pods)
+	second, _ := p.pick(req, pods)
+	if first == second {
+		t.Errorf("Burst of requests sent to the same pod %v before its metrics are refreshed", first.Pod)
+	}
+	// The requests are no longer counted once the metrics are expected to reflect them.
+	now = now.Add(2 * recentPickWindow)
+	if third, _ := p.pick(req, pods); third != first {
+		t.Errorf("Unexpected pod %v, want %v", third.Pod, first.Pod)
+	}
+}
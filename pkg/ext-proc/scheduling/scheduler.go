@@ -4,6 +4,7 @@ package scheduling
 import (
 	"fmt"
 	"math/rand"
+	"time"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -18,17 +19,39 @@ const (
 	// TODO(https://github.com/kubernetes-sigs/llm-instance-gateway/issues/16) Make this configurable.
 	queueThresholdCritical = 5
 	// TODO(https://github.com/kubernetes-sigs/llm-instance-gateway/issues/16) Make this configurable.
+	// Default requests are admitted with looser thresholds than sheddable requests, so that they
+	// are only shed once sheddable requests are already being dropped.
+	kvCacheThresholdDefault = 0.9
+	// TODO(https://github.com/kubernetes-sigs/llm-instance-gateway/issues/16) Make this configurable.
+	queueThresholdDefault = 20
+	// TODO(https://github.com/kubernetes-sigs/llm-instance-gateway/issues/16) Make this configurable.
 	// the threshold for queued requests to be considered low below which we can prioritize LoRA affinity.
 	// The value of 50 is arrived heuristicically based on experiments.
 	queueingThresholdLoRA = 50
+	// TODO(https://github.com/kubernetes-sigs/llm-instance-gateway/issues/16) Make this configurable.
+	// how far ahead the KV cache usage of the pods is projected from its trend when comparing it to
+	// the KV cache thresholds.
+	kvCacheTrendHorizon = 2 * time.Second
 )
 
+// sessionAffinityPath is the scheduling path of requests sent to the pod pinned to their session.
+const sessionAffinityPath = "session affinity"
+
+// lowestPredictedLatencyPath is the scheduling path of requests sent to the pod with the lowest
+// predicted latency.
+const lowestPredictedLatencyPath = "lowest predicted latency"
+
 var (
 	defaultFilter = &filter{
 		name:          "critical request",
 		filter:        toFilterFunc(criticalRequestPredicate),
 		nextOnSuccess: lowLatencyFilter,
-		nextOnFailure: sheddableRequestFilter,
+		nextOnFailure: &filter{
+			name:          "default request",
+			filter:        toFilterFunc(defaultRequestPredicate),
+			nextOnSuccess: defaultRequestFilter,
+			nextOnFailure: sheddableRequestFilter,
+		},
 	}
 
 	// queueLoRAAndKVCacheFilter applied least queue -> low cost lora ->  least KV Cache filter
@@ -76,31 +99,131 @@ var (
 		// cache below a certain threshold, we consider this model server has capacity to handle
 		// a sheddable request without impacting critical requests.
 		name:          "has capacity for sheddable requests",
-		filter:        toFilterFunc(noQueueAndLessThanKVCacheThresholdPredicate(queueThresholdCritical, kvCacheThreshold)),
+		filter:        toFilterFunc(hasCapacityPredicate(queueThresholdCritical, kvCacheThreshold)),
 		nextOnSuccess: queueLoRAAndKVCacheFilter,
 		// If all pods are queuing or running above the KVCache threshold, we drop the sheddable
 		// request to make room for critical requests.
+		nextOnFailure: dropRequestFilter,
+	}
+
+	defaultRequestFilter = &filter{
+		// Default requests tolerate some queuing and a higher KV cache usage than sheddable
+		// requests, so under increasing load sheddable requests are dropped first.
+		name:          "has capacity for default requests",
+		filter:        toFilterFunc(hasCapacityPredicate(queueThresholdDefault, kvCacheThresholdDefault)),
+		nextOnSuccess: queueLoRAAndKVCacheFilter,
+		// If all pods are above the looser thresholds, we drop the default request to make room
+		// for critical requests.
+		nextOnFailure: dropRequestFilter,
+	}
+
+	// latencyCapacityFilter drops the default and sheddable requests when no pod has capacity for
+	// them, like the default filter, and returns the pods with capacity for the request.
+	latencyCapacityFilter = &filter{
+		name:   "critical request",
+		filter: toFilterFunc(criticalRequestPredicate),
 		nextOnFailure: &filter{
-			name: "drop request",
-			filter: func(req *LLMRequest, pods []*backend.PodMetrics) ([]*backend.PodMetrics, error) {
-				klog.Infof("Dropping request %v", req)
-				return []*backend.PodMetrics{}, status.Errorf(codes.ResourceExhausted, "dropping request due to limited backend resources")
+			name:   "default request",
+			filter: toFilterFunc(defaultRequestPredicate),
+			nextOnSuccess: &filter{
+				name:          "has capacity for default requests",
+				filter:        toFilterFunc(hasCapacityPredicate(queueThresholdDefault, kvCacheThresholdDefault)),
+				nextOnFailure: dropRequestFilter,
+			},
+			nextOnFailure: &filter{
+				name:          "has capacity for sheddable requests",
+				filter:        toFilterFunc(hasCapacityPredicate(queueThresholdCritical, kvCacheThreshold)),
+				nextOnFailure: dropRequestFilter,
 			},
 		},
 	}
-)
 
-func NewScheduler(pmp PodMetricsProvider) *Scheduler {
+	dropRequestFilter = &filter{
+		name: "drop request",
+		filter: func(req *LLMRequest, pods []*backend.PodMetrics) ([]*backend.PodMetrics, error) {
+			klog.Infof("Dropping request %v", req)
+			return []*backend.PodMetrics{}, status.Errorf(codes.ResourceExhausted, "dropping request due to limited backend resources")
+		},
+	}
+)
 
-	return &Scheduler{
+func NewScheduler(pmp PodMetricsProvider, options ...SchedulerOption) *Scheduler {
+	s := &Scheduler{
 		podMetricsProvider: pmp,
 		filter:             defaultFilter,
 	}
+	for _, opt := range options {
+		opt(s)
+	}
+	return s
 }
 
 type Scheduler struct {
 	podMetricsProvider PodMetricsProvider
 	filter             Filter
+	// sessions pins requests of the same session to the same pod to reuse its KV cache. Session
+	// affinity is disabled if nil.
+	sessions *sessionCache
+	// decisions keeps the last scheduling decisions, if set.
+	decisions *DecisionLog
+	// decisionLogSampleRate is the fraction of the scheduling decisions which are logged.
+	decisionLogSampleRate float64
+	// baseModels are the target models served by base models rather than LoRA adapters. If set,
+	// default and sheddable requests for the other target models are only sent to pods which have
+	// the adapter loaded or a free adapter slot.
+	baseModels map[string]bool
+	// latencyPredictor picks the pod with the lowest predicted latency instead of applying the
+	// filter, if set.
+	latencyPredictor *LatencyPredictor
+}
+
+type SchedulerOption func(*Scheduler)
+
+// WithSessionAffinity enables routing requests with the same session ID to the same pod, as long
+// as the pod exists and is not overloaded. Sessions expire after ttl without requests, and at most
+// maxSessions sessions are tracked.
+func WithSessionAffinity(ttl time.Duration, maxSessions int) SchedulerOption {
+	return func(s *Scheduler) {
+		s.sessions = newSessionCache(ttl, maxSessions)
+	}
+}
+
+// WithDecisionLog records the scheduling decisions into the decision log.
+func WithDecisionLog(decisions *DecisionLog) SchedulerOption {
+	return func(s *Scheduler) {
+		s.decisions = decisions
+	}
+}
+
+// WithDecisionLogSampling logs the given fraction, between 0 and 1, of the scheduling decisions.
+func WithDecisionLogSampling(rate float64) SchedulerOption {
+	return func(s *Scheduler) {
+		s.decisionLogSampleRate = rate
+	}
+}
+
+// WithBaseModels sets the target models served by base models rather than LoRA adapters. The
+// other target models are considered adapters, which default and sheddable requests are only sent
+// to pods with the adapter loaded or a free adapter slot for, so that a request for an adapter
+// without capacity is shed, or sent to a fallback model, rather than thrashing the adapters of the
+// pods.
+func WithBaseModels(models []string) SchedulerOption {
+	return func(s *Scheduler) {
+		s.baseModels = make(map[string]bool, len(models))
+		for _, m := range models {
+			s.baseModels[m] = true
+		}
+	}
+}
+
+// WithLatencyPredictor sends the requests to the pod with the lowest latency predicted by the
+// predictor, among the pods with capacity for the criticality of the request, instead of picking a
+// pod at random among the pods passing the filter. The latencies of the requests must be observed
+// by the predictor for it to learn.
+func WithLatencyPredictor(p *LatencyPredictor) SchedulerOption {
+	return func(s *Scheduler) {
+		s.latencyPredictor = p
+	}
 }
 
 // PodMetricsProvider is an interface to provide set of pods in the backend and information such as
@@ -111,12 +234,103 @@ type PodMetricsProvider interface {
 
 // Schedule finds the target pod based on metrics and the requested lora adapter.
 func (s *Scheduler) Schedule(req *LLMRequest) (targetPod backend.Pod, err error) {
-	klog.V(3).Infof("request: %v; metrics: %+v", req, s.podMetricsProvider.AllPodMetrics())
-	pods, err := s.filter.Filter(req, s.podMetricsProvider.AllPodMetrics())
+	// The request may be scheduled again, e.g. for a fallback model, which is a separate decision.
+	req.Decision = &Decision{
+		Time:        time.Now(),
+		Model:       req.Model,
+		TargetModel: req.ResolvedTargetModel,
+		Criticality: req.Criticality,
+	}
+	defer func() {
+		s.recordDecision(req.Decision, targetPod, err)
+	}()
+	req.adapter = s.baseModels != nil && !s.baseModels[req.ResolvedTargetModel]
+	allPods := s.podMetricsProvider.AllPodMetrics()
+	klog.V(3).Infof("request: %v; metrics: %+v", req, allPods)
+	if len(allPods) == 0 {
+		return backend.Pod{}, status.Errorf(codes.Unavailable, "no pods available in the pool")
+	}
+	trackSession := s.sessions != nil && req.SessionID != ""
+	if trackSession {
+		if pod, ok := s.sessionPod(req, allPods); ok {
+			klog.V(3).Infof("Selected pod %v pinned to session %q", pod, req.SessionID)
+			req.Decision.Steps = append(req.Decision.Steps, FilterStep{
+				Filter:     sessionAffinityPath,
+				InputPods:  len(allPods),
+				OutputPods: 1,
+				Passed:     true,
+			})
+			req.Decision.Candidates = 1
+			s.sessions.put(req.SessionID, pod, time.Now())
+			return pod, nil
+		}
+	}
+	if s.latencyPredictor != nil {
+		return s.pickLowestLatency(req, allPods, trackSession)
+	}
+	pods, err := s.filter.Filter(req, allPods)
 	if err != nil || len(pods) == 0 {
 		return backend.Pod{}, fmt.Errorf("failed to apply filter, resulted %v pods, this should never happen: %w", len(pods), err)
 	}
 	klog.V(3).Infof("Going to randomly select a pod from the candidates: %+v", pods)
+	req.Decision.Candidates = len(pods)
 	i := rand.Intn(len(pods))
+	if trackSession {
+		s.sessions.put(req.SessionID, pods[i].Pod, time.Now())
+	}
 	return pods[i].Pod, nil
 }
+
+// pickLowestLatency picks the pod with capacity for the request with the lowest predicted latency.
+func (s *Scheduler) pickLowestLatency(req *LLMRequest, allPods []*backend.PodMetrics, trackSession bool) (backend.Pod, error) {
+	pods, err := latencyCapacityFilter.Filter(req, allPods)
+	if err != nil {
+		return backend.Pod{}, err
+	}
+	if len(pods) == 0 {
+		return backend.Pod{}, fmt.Errorf("failed to apply filter, resulted 0 pods, this should never happen")
+	}
+	pod, prediction := s.latencyPredictor.pick(req, pods)
+	klog.V(3).Infof("Selected pod %v with predicted time to first token %v and time per token %v", pod.Pod, prediction.TimeToFirstToken, prediction.TimePerToken)
+	req.Decision.Steps = append(req.Decision.Steps, FilterStep{
+		Filter:     lowestPredictedLatencyPath,
+		InputPods:  len(pods),
+		OutputPods: 1,
+		Passed:     true,
+	})
+	req.Decision.Candidates = 1
+	req.Prediction = prediction
+	if trackSession {
+		s.sessions.put(req.SessionID, pod.Pod, time.Now())
+	}
+	return pod.Pod, nil
+}
+
+// recordDecision completes the trace of a scheduling decision with its outcome, and records it.
+func (s *Scheduler) recordDecision(d *Decision, targetPod backend.Pod, err error) {
+	d.TargetPod = targetPod.Name
+	if err != nil {
+		d.Error = err.Error()
+	}
+	if s.decisions != nil {
+		s.decisions.Add(*d)
+	}
+	if s.decisionLogSampleRate > 0 && rand.Float64() < s.decisionLogSampleRate {
+		klog.Infof("Scheduling decision: %v", d)
+	}
+}
+
+// sessionPod returns the pod the request's session is pinned to, if the pod still exists and can
+// serve the session.
+func (s *Scheduler) sessionPod(req *LLMRequest, pods []*backend.PodMetrics) (backend.Pod, bool) {
+	pinned, ok := s.sessions.get(req.SessionID, time.Now())
+	if !ok {
+		return backend.Pod{}, false
+	}
+	for _, pod := range pods {
+		if pod.Pod == pinned {
+			return pinned, canServeSessionPredicate(req, pod)
+		}
+	}
+	return backend.Pod{}, false
+}
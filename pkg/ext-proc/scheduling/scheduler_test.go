@@ -0,0 +1,59 @@
+package scheduling
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+)
+
+// BenchmarkSchedule schedules requests over the pods of a provider, while it refreshes their metrics
+// in the background like in the ext-proc, or not.
+func BenchmarkSchedule(b *testing.B) {
+	const numPods = 200
+	var pods []*backend.PodMetrics
+	res := make(map[backend.Pod]*backend.PodMetrics)
+	for i := 0; i < numPods; i++ {
+		pm := &backend.PodMetrics{
+			Pod: backend.Pod{Name: fmt.Sprintf("pod-%d", i), Address: fmt.Sprintf("10.0.0.%d:8000", i)},
+			Metrics: backend.Metrics{
+				WaitingQueueSize:    i % 10,
+				KVCacheUsagePercent: float64(i%10) / 10,
+				MaxActiveModels:     4,
+				ActiveModels:        map[string]int{fmt.Sprintf("adapter-%d", i%8): 1},
+			},
+		}
+		pods = append(pods, pm)
+		res[pm.Pod] = pm
+	}
+	for name, refreshMetricsInterval := range map[string]time.Duration{
+		"idle":       time.Hour,
+		"refreshing": 10 * time.Millisecond,
+	} {
+		b.Run(name, func(b *testing.B) {
+			provider := backend.NewProvider(&backend.FakePodMetricsClient{Res: res}, backend.NewK8sDataStore(backend.WithPods(pods)))
+			if err := provider.Init(time.Hour, refreshMetricsInterval); err != nil {
+				b.Fatalf("Init: %v", err)
+			}
+			s := NewScheduler(provider)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					req := &LLMRequest{
+						Model:               "model",
+						ResolvedTargetModel: fmt.Sprintf("adapter-%d", i%8),
+						Criticality:         v1alpha1.Critical,
+					}
+					if _, err := s.Schedule(req); err != nil {
+						b.Fatalf("Schedule: %v", err)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package scheduling
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+)
+
+// sessionCache is a bounded map from session IDs to the pod that served the last request of the
+// session. Entries expire after ttl, and the least recently used entry is evicted when the cache
+// is full.
+type sessionCache struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	maxSessions int
+	// lru holds *sessionEntry values, the most recently used entry at the front.
+	lru      *list.List
+	sessions map[string]*list.Element
+}
+
+type sessionEntry struct {
+	id       string
+	pod      backend.Pod
+	lastSeen time.Time
+}
+
+func newSessionCache(ttl time.Duration, maxSessions int) *sessionCache {
+	return &sessionCache{
+		ttl:         ttl,
+		maxSessions: maxSessions,
+		lru:         list.New(),
+		sessions:    make(map[string]*list.Element),
+	}
+}
+
+// get returns the pod the session is pinned to, if the session exists and hasn't expired.
+func (c *sessionCache) get(id string, now time.Time) (backend.Pod, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.sessions[id]
+	if !ok {
+		return backend.Pod{}, false
+	}
+	entry := elem.Value.(*sessionEntry)
+	if now.Sub(entry.lastSeen) > c.ttl {
+		c.remove(elem)
+		return backend.Pod{}, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.pod, true
+}
+
+// put pins the session to the pod and refreshes its expiry.
+func (c *sessionCache) put(id string, pod backend.Pod, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.sessions[id]; ok {
+		entry := elem.Value.(*sessionEntry)
+		entry.pod = pod
+		entry.lastSeen = now
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.sessions[id] = c.lru.PushFront(&sessionEntry{id: id, pod: pod, lastSeen: now})
+	for c.lru.Len() > c.maxSessions {
+		oldest := c.lru.Back()
+		if oldest == nil {
+			break
+		}
+		c.remove(oldest)
+	}
+}
+
+func (c *sessionCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.sessions, elem.Value.(*sessionEntry).id)
+}
+
+// canServeSessionPredicate checks whether a pod a session is pinned to can keep serving the
+// session without overloading it. Default and sheddable requests must also pass the capacity check
+// of their criticality, so that pinning a session does not bypass load shedding.
+func canServeSessionPredicate(req *LLMRequest, pod *backend.PodMetrics) bool {
+	if pod.WaitingQueueSize >= queueingThresholdLoRA || projectedKVCacheUsage(pod) >= kvCacheThreshold {
+		return false
+	}
+	switch req.Criticality {
+	case v1alpha1.Critical:
+		return true
+	case v1alpha1.Default:
+		return hasCapacityPredicate(queueThresholdDefault, kvCacheThresholdDefault)(req, pod)
+	default:
+		return hasCapacityPredicate(queueThresholdCritical, kvCacheThreshold)(req, pod)
+	}
+}
@@ -0,0 +1,171 @@
+package scheduling
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/backend"
+)
+
+type fakePodMetricsProvider struct {
+	pods []*backend.PodMetrics
+}
+
+func (f *fakePodMetricsProvider) AllPodMetrics() []*backend.PodMetrics {
+	return f.pods
+}
+
+func TestSessionCache(t *testing.T) {
+	now := time.Now()
+	pod1 := backend.Pod{Name: "pod1"}
+	pod2 := backend.Pod{Name: "pod2"}
+	c := newSessionCache(time.Minute, 2)
+
+	c.put("a", pod1, now)
+	c.put("b", pod2, now)
+	if got, ok := c.get("a", now); !ok || got != pod1 {
+		t.Errorf("get(a) = %v, %v, want %v, true", got, ok, pod1)
+	}
+	// "b" is the least recently used session and is evicted.
+	c.put("c", pod2, now)
+	if _, ok := c.get("b", now); ok {
+		t.Errorf("get(b) found an evicted session")
+	}
+	if got, ok := c.get("c", now); !ok || got != pod2 {
+		t.Errorf("get(c) = %v, %v, want %v, true", got, ok, pod2)
+	}
+	if _, ok := c.get("a", now.Add(2*time.Minute)); ok {
+		t.Errorf("get(a) found an expired session")
+	}
+	if len(c.sessions) != 1 || c.lru.Len() != 1 {
+		t.Errorf("Expired session was not removed, sessions: %v", c.sessions)
+	}
+}
+
+func TestSessionCacheWithoutSessions(t *testing.T) {
+	now := time.Now()
+	for _, maxSessions := range []int{0, -1} {
+		c := newSessionCache(time.Minute, maxSessions)
+		c.put("a", backend.Pod{Name: "pod1"}, now)
+		if _, ok := c.get("a", now); ok {
+			t.Errorf("get(a) with maxSessions %d found a session", maxSessions)
+		}
+	}
+}
+
+func TestScheduleWithSessionAffinity(t *testing.T) {
+	pod1 := &backend.PodMetrics{
+		Pod: backend.Pod{Name: "pod1"},
+		Metrics: backend.Metrics{
+			KVCacheUsagePercent: 0.1,
+			ActiveModels:        map[string]int{},
+		},
+	}
+	pod2 := &backend.PodMetrics{
+		Pod: backend.Pod{Name: "pod2"},
+		Metrics: backend.Metrics{
+			KVCacheUsagePercent: 0.1,
+			ActiveModels:        map[string]int{},
+		},
+	}
+	overloaded := &backend.PodMetrics{
+		Pod: backend.Pod{Name: "pod2"},
+		Metrics: backend.Metrics{
+			WaitingQueueSize:    100,
+			KVCacheUsagePercent: 0.95,
+			ActiveModels:        map[string]int{},
+		},
+	}
+	tests := []struct {
+		name string
+		// pinned is the pod the session is pinned to before scheduling.
+		pinned backend.Pod
+		pods   []*backend.PodMetrics
+		want   backend.Pod
+	}{
+		{
+			name:   "pinned pod is healthy",
+			pinned: pod2.Pod,
+			pods:   []*backend.PodMetrics{pod1, pod2},
+			want:   pod2.Pod,
+		},
+		{
+			name:   "pinned pod is overloaded",
+			pinned: pod2.Pod,
+			pods:   []*backend.PodMetrics{pod1, overloaded},
+			want:   pod1.Pod,
+		},
+		{
+			name:   "pinned pod is gone",
+			pinned: pod2.Pod,
+			pods:   []*backend.PodMetrics{pod1},
+			want:   pod1.Pod,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewScheduler(&fakePodMetricsProvider{pods: test.pods}, WithSessionAffinity(time.Minute, 10))
+			s.sessions.put("session", test.pinned, time.Now())
+			req := &LLMRequest{
+				Model:               "model",
+				ResolvedTargetModel: "model",
+				Criticality:         v1alpha1.Critical,
+				SessionID:           "session",
+			}
+			got, err := s.Schedule(req)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("Schedule() = %v, want %v", got, test.want)
+			}
+			if pinned, _ := s.sessions.get("session", time.Now()); pinned != test.want {
+				t.Errorf("Session pinned to %v, want %v", pinned, test.want)
+			}
+		})
+	}
+}
+
+func TestScheduleWithSessionAffinityLoadShedding(t *testing.T) {
+	// The pod is under the session thresholds, but over the capacity thresholds of sheddable and
+	// default requests.
+	busy := &backend.PodMetrics{
+		Pod: backend.Pod{Name: "busy"},
+		Metrics: backend.Metrics{
+			WaitingQueueSize:    30,
+			KVCacheUsagePercent: 0.5,
+			ActiveModels:        map[string]int{},
+		},
+	}
+	tests := []struct {
+		criticality v1alpha1.Criticality
+		wantCode    codes.Code
+	}{
+		{criticality: v1alpha1.Critical, wantCode: codes.OK},
+		{criticality: v1alpha1.Default, wantCode: codes.ResourceExhausted},
+		{criticality: v1alpha1.Sheddable, wantCode: codes.ResourceExhausted},
+	}
+	for _, test := range tests {
+		t.Run(string(test.criticality), func(t *testing.T) {
+			s := NewScheduler(&fakePodMetricsProvider{pods: []*backend.PodMetrics{busy}}, WithSessionAffinity(time.Minute, 10))
+			s.sessions.put("session", busy.Pod, time.Now())
+			req := &LLMRequest{
+				Model:               "model",
+				ResolvedTargetModel: "model",
+				Criticality:         test.criticality,
+				SessionID:           "session",
+			}
+			got, err := s.Schedule(req)
+			if status.Code(err) != test.wantCode {
+				t.Fatalf("Unexpected error, got %v, want code %v", err, test.wantCode)
+			}
+			if err == nil && got != busy.Pod {
+				t.Errorf("Schedule() = %v, want %v", got, busy.Pod)
+			}
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package scheduling
 
+import "inference.networking.x-k8s.io/llm-instance-gateway/api/v1alpha1"
+
 // LLMRequest is a structured representation of the fields we parse out of the LLMRequest body.
 type LLMRequest struct {
 	Model string
@@ -7,5 +9,23 @@ type LLMRequest struct {
 	TargetModels map[string]int
 	// Resolved target model is the final target model after traffic split.
 	ResolvedTargetModel string
-	Critical            bool
+	// Criticality determines how the request is treated when the backend is under load: Critical
+	// requests are always admitted, Default requests are admitted with looser thresholds than
+	// Sheddable requests, and Sheddable requests are dropped first.
+	Criticality v1alpha1.Criticality
+	// SessionID identifies the session, such as a multi-turn conversation, the request belongs to.
+	// It is empty if the request is not part of a session.
+	SessionID string
+	// PromptTokens is the estimated number of tokens of the prompt.
+	PromptTokens int
+	// MaxTokens is the maximum number of tokens to generate, or 0 if not set.
+	MaxTokens int
+	// Decision is set by the scheduler to the trace of the scheduling decision of the request.
+	Decision *Decision
+	// adapter is set by the scheduler to whether the target model is served by a LoRA adapter, if
+	// the scheduler knows the base models.
+	adapter bool
+	// Prediction is set by the scheduler to the predicted latency of the request on the target pod,
+	// if the scheduler predicts latencies.
+	Prediction *LatencyPrediction
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"inference.networking.x-k8s.io/llm-instance-gateway/pkg/ext-proc/jsonbody"
+)
+
+// BenchmarkModelRewrite compares rewriting the model of request bodies by decoding and encoding
+// the whole body, as the ext-proc used to, with rewriting only the model field.
+func BenchmarkModelRewrite(b *testing.B) {
+	for _, size := range []int{1 << 10, 100 << 10, 1 << 20} {
+		body := requestBody(size)
+		b.Run(fmt.Sprintf("unmarshal/%dKB", size>>10), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			for i := 0; i < b.N; i++ {
+				var rb map[string]interface{}
+				if err := json.Unmarshal(body, &rb); err != nil {
+					b.Fatal(err)
+				}
+				rb["model"] = "my-model-v1"
+				if _, err := json.Marshal(rb); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("scan/%dKB", size>>10), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			for i := 0; i < b.N; i++ {
+				rb, err := jsonbody.Parse(body)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, ok := rb.String("model"); !ok {
+					b.Fatal("model not found")
+				}
+				rb.WithString("model", "my-model-v1")
+			}
+		})
+	}
+}
+
+// requestBody returns a chat completion request body of about size bytes.
+func requestBody(size int) []byte {
+	content := strings.Repeat("The quick brown fox jumps over the lazy dog. ", size/45+1)[:size]
+	body, err := json.Marshal(map[string]interface{}{
+		"model":       "my-model",
+		"messages":    []map[string]string{{"role": "system", "content": "You are a helpful assistant."}, {"role": "user", "content": content}},
+		"max_tokens":  100,
+		"temperature": 0,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return body
+}
@@ -13,6 +13,7 @@ import (
 
 	configPb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	envoyTypePb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
 	"github.com/google/go-cmp/cmp"
 	"google.golang.org/protobuf/testing/protocmp"
 
@@ -32,7 +33,9 @@ func TestHandleRequestBody(t *testing.T) {
 		models      map[string]*v1alpha1.InferenceModel
 		wantHeaders []*configPb.HeaderValueOption
 		wantBody    []byte
-		wantErr     bool
+		// wantImmediateResponse is set if the request is expected to be rejected.
+		wantImmediateResponse *extProcPb.ImmediateResponse
+		wantErr               bool
 	}{
 		{
 			name: "success",
@@ -102,6 +105,34 @@ func TestHandleRequestBody(t *testing.T) {
 			},
 			wantBody: []byte("{\"max_tokens\":100,\"model\":\"my-model-v1\",\"prompt\":\"hello\",\"temperature\":0}"),
 		},
+		{
+			name: "unknown model",
+			req:  GenerateRequest("unknown-model"),
+			pods: []*backend.PodMetrics{
+				{
+					Pod: FakePod(0),
+					Metrics: backend.Metrics{
+						ActiveModels: map[string]int{},
+					},
+				},
+			},
+			wantImmediateResponse: &extProcPb.ImmediateResponse{
+				Status: &envoyTypePb.HttpStatus{
+					Code: envoyTypePb.StatusCode_NotFound,
+				},
+				Headers: &extProcPb.HeaderMutation{
+					SetHeaders: []*configPb.HeaderValueOption{
+						{
+							Header: &configPb.HeaderValue{
+								Key:      "content-type",
+								RawValue: []byte("application/json"),
+							},
+						},
+					},
+				},
+				Body: []byte(`{"error":{"message":"The requested model does not exist.","type":"invalid_request_error","code":"model_not_found"}}`),
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -124,6 +155,13 @@ func TestHandleRequestBody(t *testing.T) {
 					},
 				},
 			}
+			if test.wantImmediateResponse != nil {
+				want = &extProcPb.ProcessingResponse{
+					Response: &extProcPb.ProcessingResponse_ImmediateResponse{
+						ImmediateResponse: test.wantImmediateResponse,
+					},
+				}
+			}
 			res, err := sendRequest(t, client, test.req)
 
 			if (err != nil) != test.wantErr {